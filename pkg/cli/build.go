@@ -1,7 +1,11 @@
 package cli
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
 
 	"github.com/sieve-data/cog/pkg/config"
 	"github.com/sieve-data/cog/pkg/image"
@@ -11,6 +15,9 @@ import (
 
 var buildTag string
 var buildProgressOutput string
+var buildExplain bool
+var buildPlatforms []string
+var buildReproducible bool
 
 func newBuildCommand() *cobra.Command {
 	cmd := &cobra.Command{
@@ -20,7 +27,11 @@ func newBuildCommand() *cobra.Command {
 		RunE:  buildCommand,
 	}
 	addBuildProgressOutputFlag(cmd)
+	addPlatformFlag(cmd)
+	addReproducibleFlags(cmd)
+	addBuildCacheFlags(cmd)
 	cmd.Flags().StringVarP(&buildTag, "tag", "t", "", "A name for the built image in the form 'repository:tag'")
+	cmd.Flags().BoolVar(&buildExplain, "explain", false, "Explain how the CUDA version, cuDNN version and base image were chosen")
 	return cmd
 }
 
@@ -30,6 +41,13 @@ func buildCommand(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if err := applyPlatformFlag(cfg); err != nil {
+		return err
+	}
+	if err := applyReproducibleFlags(projectDir); err != nil {
+		return err
+	}
+
 	imageName := cfg.Image
 	if buildTag != "" {
 		imageName = buildTag
@@ -38,7 +56,11 @@ func buildCommand(cmd *cobra.Command, args []string) error {
 		imageName = config.DockerImageName(projectDir)
 	}
 
-	if err := image.Build(cfg, projectDir, imageName, buildProgressOutput, os.Stderr); err != nil {
+	if buildExplain {
+		explainCUDADecision(cfg)
+	}
+
+	if _, err := image.Build(cfg, projectDir, imageName, buildProgressOutput, os.Stderr, nil); err != nil {
 		return err
 	}
 
@@ -47,6 +69,17 @@ func buildCommand(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func explainCUDADecision(cfg *config.Config) {
+	decision := cfg.CUDADecision()
+	if decision == nil {
+		console.Info("This model doesn't use a GPU, so Cog didn't choose a CUDA version")
+		return
+	}
+	console.Info("How Cog chose the CUDA version, cuDNN version and base image:")
+	console.Info(decision.String())
+	console.Info("")
+}
+
 func addBuildProgressOutputFlag(cmd *cobra.Command) {
 	defaultOutput := "auto"
 	if os.Getenv("TERM") == "dumb" {
@@ -54,3 +87,63 @@ func addBuildProgressOutputFlag(cmd *cobra.Command) {
 	}
 	cmd.Flags().StringVar(&buildProgressOutput, "progress", defaultOutput, "Set type of build progress output, 'auto' (default), 'tty' or 'plain'")
 }
+
+func addPlatformFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&buildPlatforms, "platform", nil, "Platforms to build the image for, like 'linux/amd64,linux/arm64'. Overrides 'platforms' in cog.yaml. Images for more than one platform are pushed as they are built")
+}
+
+// applyPlatformFlag overrides the platforms in cog.yaml with the --platform flag
+func applyPlatformFlag(cfg *config.Config) error {
+	if len(buildPlatforms) == 0 {
+		return nil
+	}
+	for _, platform := range buildPlatforms {
+		if _, _, err := config.ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
+	cfg.Build.Platforms = buildPlatforms
+	return nil
+}
+
+func addReproducibleFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&config.BuildSourceEpochTimestamp, "timestamp", -1, "Seconds since the Unix epoch to set as SOURCE_DATE_EPOCH and the timestamp of files in the image's layers. -1 doesn't rewrite timestamps")
+	cmd.Flags().BoolVar(&buildReproducible, "reproducible", false, "Pin base images to digests and rewrite timestamps, so building the same commit makes the same image. The timestamp defaults to the time of the last git commit")
+}
+
+func addBuildCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&config.BuildCacheFrom, "cache-from", nil, "Where to import the build cache from, in the format of docker buildx build --cache-from, like 'type=registry,ref=r8.im/user/model:buildcache'. Overrides cache.from in cog.yaml")
+	cmd.Flags().StringArrayVar(&config.BuildCacheTo, "cache-to", nil, "Where to export the build cache to, in the format of docker buildx build --cache-to, like 'type=local,dest=.cache'. Overrides cache.to in cog.yaml")
+	cmd.Flags().StringVar(&config.BuildXCachePath, "cache-dir", "", "A local directory to import the build cache from and export it to")
+	cmd.Flags().BoolVar(&config.BuildNoCache, "no-cache", false, "Build without using the cache")
+}
+
+// applyReproducibleFlags sets up a reproducible build for --reproducible. Without --timestamp, the time of
+// the last commit is used, so builds of the same commit have the same timestamps.
+func applyReproducibleFlags(projectDir string) error {
+	if !buildReproducible {
+		return nil
+	}
+	config.BuildReproducible = true
+	if config.BuildSourceEpochTimestamp >= 0 {
+		return nil
+	}
+	timestamp, err := gitCommitTimestamp(projectDir)
+	if err != nil {
+		return fmt.Errorf("--reproducible needs a timestamp to build with. Pass --timestamp, or build from a git repository: %w", err)
+	}
+	config.BuildSourceEpochTimestamp = timestamp
+	console.Infof("Building reproducibly with timestamp %d", timestamp)
+	return nil
+}
+
+// gitCommitTimestamp returns the commit time of HEAD in the git repository dir is in
+func gitCommitTimestamp(dir string) (int64, error) {
+	cmd := exec.Command("git", "log", "-1", "--format=%ct")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/global"
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+var (
+	checkJSON   bool
+	checkStrict bool
+)
+
+func newCheckCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "check",
+		Aliases: []string{"lint"},
+		Short:   "Validate " + global.ConfigFilename + " and the project without building it",
+		Long: `Validate ` + global.ConfigFilename + ` and the project without building it.
+
+Checks that the config is valid, that the 'predict' and 'train' references
+point to classes that exist, that Python requirements can be parsed and are
+pinned, and that the pinned versions of PyTorch and TensorFlow are known to
+work with the selected Python and CUDA versions.
+
+Exits with a non-zero status if any errors are found, so it can be used in CI.`,
+		RunE: cmdCheck,
+		Args: cobra.NoArgs,
+	}
+	cmd.Flags().BoolVar(&checkJSON, "json", false, "Output problems as JSON")
+	cmd.Flags().BoolVar(&checkStrict, "strict", false, "Exit with a non-zero status on warnings as well as errors")
+
+	return cmd
+}
+
+func cmdCheck(cmd *cobra.Command, args []string) error {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		return err
+	}
+
+	result, err := config.Check(projectDir)
+	if err != nil {
+		return err
+	}
+
+	if checkJSON {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		console.Output(string(data))
+	} else {
+		for _, problem := range result.Problems {
+			msg := problem.Message
+			if problem.Field != "" {
+				msg = problem.Field + ": " + msg
+			}
+			if problem.Severity == config.SeverityError {
+				console.Error(msg)
+			} else {
+				console.Warn(msg)
+			}
+		}
+	}
+
+	errorCount := result.Count(config.SeverityError)
+	warningCount := result.Count(config.SeverityWarning)
+	if errorCount > 0 || (checkStrict && warningCount > 0) {
+		return fmt.Errorf("Found %d errors and %d warnings in %s", errorCount, warningCount, global.ConfigFilename)
+	}
+	if !checkJSON {
+		if warningCount > 0 {
+			console.Infof("Found %d warnings in %s", warningCount, global.ConfigFilename)
+		} else {
+			console.Infof("No problems found in %s", global.ConfigFilename)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+var (
+	compatJSON        bool
+	compatPython      string
+	compatTorch       string
+	compatTensorflow  string
+	compatCUDA        string
+	compatCuDNN       string
+	compatTorchMinors bool
+)
+
+func newCompatCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compat",
+		Short: "Show the CUDA, PyTorch and TensorFlow versions Cog knows work together",
+		Long: `Show the CUDA, PyTorch and TensorFlow versions Cog knows work together.
+
+Cog uses these tables to pick CUDA versions, cuDNN versions and base images
+when it builds a model. Filter them to find out which combinations work, for
+example:
+
+  cog compat cuda --python 3.11 --torch 2.1`,
+	}
+	cmd.PersistentFlags().BoolVar(&compatJSON, "json", false, "Output as JSON")
+	cmd.PersistentFlags().StringVar(&compatPython, "python", "", "Only show versions that support this Python version")
+	cmd.PersistentFlags().StringVar(&compatTorch, "torch", "", "Only show versions that work with this PyTorch version")
+	cmd.PersistentFlags().StringVar(&compatTensorflow, "tensorflow", "", "Only show versions that work with this TensorFlow version")
+	cmd.PersistentFlags().StringVar(&compatCUDA, "cuda", "", "Only show versions that work with this CUDA version")
+	cmd.PersistentFlags().StringVar(&compatCuDNN, "cudnn", "", "Only show versions that work with this cuDNN version")
+
+	torchCmd := &cobra.Command{
+		Use:   "torch",
+		Short: "Show the PyTorch builds for each CUDA version",
+		RunE:  cmdCompatTorch,
+		Args:  cobra.NoArgs,
+	}
+	torchCmd.Flags().BoolVar(&compatTorchMinors, "minor", false, "Show the builds that are used when only a minor version of PyTorch is given")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "cuda",
+			Short: "Show the combinations of CUDA, cuDNN and Ubuntu that have base images, and the PyTorch and TensorFlow versions they work with",
+			RunE:  cmdCompatCUDA,
+			Args:  cobra.NoArgs,
+		},
+		torchCmd,
+		&cobra.Command{
+			Use:     "tensorflow",
+			Aliases: []string{"tf"},
+			Short:   "Show the CUDA and cuDNN versions for each TensorFlow version",
+			RunE:    cmdCompatTensorflow,
+			Args:    cobra.NoArgs,
+		},
+		&cobra.Command{
+			Use:   "images",
+			Short: "Show the CUDA base images",
+			RunE:  cmdCompatImages,
+			Args:  cobra.NoArgs,
+		},
+	)
+	return cmd
+}
+
+func compatFilter() config.CompatibilityFilter {
+	return config.CompatibilityFilter{
+		Python: compatPython,
+		Torch:  compatTorch,
+		TF:     compatTensorflow,
+		CUDA:   compatCUDA,
+		CuDNN:  compatCuDNN,
+	}
+}
+
+func cmdCompatCUDA(cmd *cobra.Command, args []string) error {
+	compats, err := config.CUDACompatibilities(compatFilter())
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, compat := range compats {
+		rows = append(rows, []string{compat.CUDA, compat.CuDNN, strings.Join(compat.Ubuntu, ", "), strings.Join(compat.Torch, ", "), strings.Join(compat.TF, ", ")})
+	}
+	return outputCompat(compats, []string{"CUDA", "CUDNN", "UBUNTU", "TORCH", "TENSORFLOW"}, rows)
+}
+
+func cmdCompatTorch(cmd *cobra.Command, args []string) error {
+	matrix := config.TorchCompatibilityMatrix
+	if compatTorchMinors {
+		matrix = config.TorchMinorCompatibilityMatrix
+	}
+	compats, err := config.FilterTorchCompatibilities(matrix, compatFilter())
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, compat := range compats {
+		cuda := "cpu"
+		if compat.CUDA != nil {
+			cuda = *compat.CUDA
+		}
+		index := compat.ExtraIndexURL
+		if index == "" {
+			index = compat.FindLinks
+		}
+		rows = append(rows, []string{compat.Torch, compat.Torchvision, compat.Torchaudio, cuda, strings.Join(compat.Pythons, ", "), index})
+	}
+	return outputCompat(compats, []string{"TORCH", "TORCHVISION", "TORCHAUDIO", "CUDA", "PYTHON", "INDEX"}, rows)
+}
+
+func cmdCompatTensorflow(cmd *cobra.Command, args []string) error {
+	compats, err := config.FilterTFCompatibilities(compatFilter())
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, compat := range compats {
+		rows = append(rows, []string{compat.TF, compat.CUDA, compat.CuDNN, strings.Join(compat.Pythons, ", "), compat.TFGPUPackage})
+	}
+	return outputCompat(compats, []string{"TENSORFLOW", "CUDA", "CUDNN", "PYTHON", "GPU PACKAGE"}, rows)
+}
+
+func cmdCompatImages(cmd *cobra.Command, args []string) error {
+	images, err := config.FilterCUDABaseImages(compatFilter())
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, image := range images {
+		rows = append(rows, []string{image.ImageTag(), image.CUDA, image.CuDNN, image.Ubuntu, fmt.Sprintf("%t", image.IsDevel)})
+	}
+	return outputCompat(images, []string{"IMAGE", "CUDA", "CUDNN", "UBUNTU", "DEVEL"}, rows)
+}
+
+// outputCompat prints v as JSON if --json is set, otherwise it prints the rows as a table
+func outputCompat(v any, header []string, rows [][]string) error {
+	if compatJSON {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		console.Output(string(data))
+		return nil
+	}
+
+	if len(rows) == 0 {
+		console.Info("Nothing matches the filters")
+		return nil
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	console.Output(strings.TrimSuffix(buf.String(), "\n"))
+	return nil
+}
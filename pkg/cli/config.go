@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/global"
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+var configMigrateDryRun bool
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage " + global.ConfigFilename,
+	}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Rewrite deprecated options in " + global.ConfigFilename + " to the options that replaced them",
+		Long: `Rewrite deprecated options in ` + global.ConfigFilename + ` to the options that replaced them.
+
+The commands in pre_install are moved to the start of run, with
+stage: before_requirements, so they still run before Python packages are
+installed. Comments and the order of the other options are kept.`,
+		RunE: cmdConfigMigrate,
+		Args: cobra.NoArgs,
+	}
+	migrateCmd.Flags().BoolVar(&configMigrateDryRun, "dry-run", false, "Print the migrated "+global.ConfigFilename+" instead of writing it")
+
+	cmd.AddCommand(migrateCmd)
+	return cmd
+}
+
+func cmdConfigMigrate(cmd *cobra.Command, args []string) error {
+	projectDir, err := config.GetProjectDir(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	configPath := path.Join(projectDir, global.ConfigFilename)
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return err
+	}
+	contents, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	migrated, changes, err := config.Migrate(contents)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		console.Infof("%s doesn't use any deprecated options", global.ConfigFilename)
+		return nil
+	}
+	if configMigrateDryRun {
+		console.Output(string(migrated))
+		return nil
+	}
+
+	if err := os.WriteFile(configPath, migrated, info.Mode().Perm()); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		console.Info(change)
+	}
+	console.Infof("Wrote %s", configPath)
+	return nil
+}
@@ -1,9 +1,11 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 
 	"github.com/sieve-data/cog/pkg/config"
 	"github.com/sieve-data/cog/pkg/dockerfile"
@@ -11,31 +13,61 @@ import (
 	"github.com/sieve-data/cog/pkg/util/console"
 )
 
+var (
+	debugContextDir string
+	debugConfigJSON bool
+)
+
 func newDebugCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:    "debug",
+		Short:  "Show what Cog generates from " + global.ConfigFilename,
 		Hidden: true,
-		RunE:   cmdDockerfile,
+		// For backwards compatibility, cog debug prints the Dockerfile
+		RunE: cmdDebugDockerfile,
+		Args: cobra.NoArgs,
 	}
 
-	debug := &cobra.Command{
-		Use:    "debug",
-		Short:  "Generate a Dockerfile from " + global.ConfigFilename,
-		Hidden: true,
+	dockerfileCmd := &cobra.Command{
+		Use:   "dockerfile",
+		Short: "Print the Dockerfile generated from " + global.ConfigFilename,
+		Long: `Print the Dockerfile generated from ` + global.ConfigFilename + `.
+
+The Dockerfile copies files Cog generates, like requirements and the cog
+wheel, from a named build context, cog-tmp. The files are deleted when the
+command exits. To build it yourself, pass --context-dir to copy the build
+context with those files and the Dockerfile to a directory, then run the
+command it prints, which looks like:
+
+  docker build --build-context cog-tmp=<dir>/.cog/tmp/build-<id> <dir>`,
+		RunE: cmdDebugDockerfile,
+		Args: cobra.NoArgs,
 	}
+	dockerfileCmd.Flags().StringVar(&debugContextDir, "context-dir", "", "Copy the build context, with the generated files and the Dockerfile, to this directory")
+	addPlatformFlag(dockerfileCmd)
 
-	cmd.AddCommand(debug)
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Print " + global.ConfigFilename + " with the versions and defaults Cog fills in",
+		RunE:  cmdDebugConfig,
+		Args:  cobra.NoArgs,
+	}
+	configCmd.Flags().BoolVar(&debugConfigJSON, "json", false, "Output as JSON")
 
+	cmd.AddCommand(dockerfileCmd, configCmd)
 	return cmd
 }
 
-func cmdDockerfile(cmd *cobra.Command, args []string) error {
-	config, projectDir, err := config.GetConfig(projectDirFlag)
+func cmdDebugDockerfile(cmd *cobra.Command, args []string) error {
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
 	if err != nil {
 		return err
 	}
+	if err := applyPlatformFlag(cfg); err != nil {
+		return err
+	}
 
-	generator, err := dockerfile.NewGenerator(config, projectDir)
+	generator, err := dockerfile.NewGenerator(cfg, projectDir)
 	if err != nil {
 		return fmt.Errorf("Error creating Dockerfile generator: %w", err)
 	}
@@ -48,6 +80,34 @@ func cmdDockerfile(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	console.Output(out)
+
+	if debugContextDir == "" {
+		console.Output(out)
+		return nil
+	}
+	buildCommand, err := generator.ExportContext(debugContextDir, out)
+	if err != nil {
+		return err
+	}
+	console.Infof("Wrote the build context to %s. Build it with:\n\n  %s", debugContextDir, buildCommand)
+	return nil
+}
+
+func cmdDebugConfig(cmd *cobra.Command, args []string) error {
+	cfg, _, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if debugConfigJSON {
+		out, err = json.MarshalIndent(cfg, "", "  ")
+	} else {
+		out, err = yaml.Marshal(cfg)
+	}
+	if err != nil {
+		return err
+	}
+	console.Output(string(out))
 	return nil
 }
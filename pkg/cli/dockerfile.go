@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/global"
+	"github.com/sieve-data/cog/pkg/image"
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+var (
+	ejectBuildDir string
+	ejectForce    bool
+)
+
+func newDockerfileCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dockerfile",
+		Short: "Work with the Dockerfile Cog generates",
+	}
+
+	ejectCmd := &cobra.Command{
+		Use:   "eject",
+		Short: "Write a Dockerfile to the project, so it can be built without Cog",
+		Long: `Write a Dockerfile to the project, so it can be built without Cog.
+
+Writes the Dockerfile Cog generates from ` + global.ConfigFilename + `, a .dockerignore, and
+the files the Dockerfile copies, like the Python requirements and the cog
+wheel, to the project. The files are written to cog-build, or the directory
+given with --build-dir, and are kept so the project can be built with:
+
+  docker build .`,
+		RunE: cmdDockerfileEject,
+		Args: cobra.NoArgs,
+	}
+	ejectCmd.Flags().StringVar(&ejectBuildDir, "build-dir", image.DefaultEjectBuildDir, "The directory in the project to write the files the Dockerfile copies to")
+	ejectCmd.Flags().BoolVar(&ejectForce, "force", false, "Replace the Dockerfile and build directory if they exist")
+	addPlatformFlag(ejectCmd)
+
+	cmd.AddCommand(ejectCmd)
+	return cmd
+}
+
+func cmdDockerfileEject(cmd *cobra.Command, args []string) error {
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	if err := applyPlatformFlag(cfg); err != nil {
+		return err
+	}
+
+	written, err := image.Eject(cfg, projectDir, ejectBuildDir, ejectForce)
+	if err != nil {
+		return err
+	}
+	for _, name := range written {
+		console.Infof("Wrote %s", name)
+	}
+	console.Infof("\nBuild the image with: docker build %s", projectDir)
+	return nil
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/image"
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+func newLockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Pin the Python requirements and their dependencies to exact versions with hashes",
+		Long: `Pin the Python requirements and their dependencies to exact versions with hashes.
+
+Resolves the Python requirements in cog.yaml with uv, using the builds of
+packages like PyTorch that Cog picks for your CUDA version, and writes them to
+requirements.lock. When requirements.lock exists, cog build installs it instead
+of resolving the requirements again, so builds are reproducible.
+
+Images for more than one platform get a lockfile for each architecture, like
+requirements-amd64.lock and requirements-arm64.lock.`,
+		RunE: cmdLock,
+		Args: cobra.NoArgs,
+	}
+	addPlatformFlag(cmd)
+	return cmd
+}
+
+func cmdLock(cmd *cobra.Command, args []string) error {
+	cfg, projectDir, err := config.GetConfig(projectDirFlag)
+	if err != nil {
+		return err
+	}
+	if err := applyPlatformFlag(cfg); err != nil {
+		return err
+	}
+
+	filenames, err := image.Lock(cfg, projectDir)
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		console.Infof("Wrote %s", filename)
+	}
+	return nil
+}
@@ -24,6 +24,9 @@ func newPushCommand() *cobra.Command {
 		Args:    cobra.MaximumNArgs(1),
 	}
 	addBuildProgressOutputFlag(cmd)
+	addPlatformFlag(cmd)
+	addReproducibleFlags(cmd)
+	addBuildCacheFlags(cmd)
 
 	return cmd
 }
@@ -43,14 +46,24 @@ func push(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("To push images, you must either set the 'image' option in cog.yaml or pass an image name as an argument. For example, 'cog push registry.hooli.corp/hotdog-detector'")
 	}
 
+	if err := applyPlatformFlag(cfg); err != nil {
+		return err
+	}
+	if err := applyReproducibleFlags(projectDir); err != nil {
+		return err
+	}
+
 	var emptySlice []string
 	if _, err := image.Build(cfg, projectDir, imageName, buildProgressOutput, os.Stderr, emptySlice); err != nil {
 		return err
 	}
 
-	console.Infof("\nPushing image '%s'...", imageName)
-
-	exitStatus := docker.Push(imageName)
+	// Multi-platform images are pushed as they are built, because they can't be loaded locally
+	var exitStatus error
+	if len(cfg.Platforms()) == 1 {
+		console.Infof("\nPushing image '%s'...", imageName)
+		exitStatus = docker.Push(imageName)
+	}
 	if exitStatus == nil {
 		console.Infof("Image '%s' pushed", imageName)
 		replicatePrefix := fmt.Sprintf("%s/", global.ReplicateRegistryHost)
@@ -39,8 +39,13 @@ https://github.com/sieve-data/cog`,
 
 	rootCmd.AddCommand(
 		newBuildCommand(),
+		newCheckCommand(),
+		newCompatCommand(),
+		newConfigCommand(),
 		newDebugCommand(),
+		newDockerfileCommand(),
 		newInitCommand(),
+		newLockCommand(),
 		newLoginCommand(),
 		newPredictCommand(),
 		newPushCommand(),
@@ -1,6 +1,9 @@
 package cli
 
 import (
+	"fmt"
+	"os"
+	"runtime"
 	"strconv"
 	"strings"
 
@@ -13,6 +16,7 @@ import (
 
 var (
 	runPorts []string
+	runUser  string
 )
 
 func newRunCommand() *cobra.Command {
@@ -29,6 +33,7 @@ func newRunCommand() *cobra.Command {
 
 	// This is called `publish` for consistency with `docker run`
 	cmd.Flags().StringArrayVarP(&runPorts, "publish", "p", []string{}, "Publish a container's port to the host, e.g. -p 8000")
+	cmd.Flags().StringVarP(&runUser, "user", "u", "", "The user to run the command as, in the form uid[:gid], like $(id -u):$(id -g). If user is set in cog.yaml, this defaults to your user on Linux, so files the command creates in /src are owned by you")
 
 	flags.SetInterspersed(false)
 
@@ -59,6 +64,15 @@ func run(cmd *cobra.Command, args []string) error {
 		Workdir: "/src",
 	}
 
+	runOptions.User = runUser
+	if runOptions.User == "" && cfg.Build.User != nil && runtime.GOOS == "linux" {
+		// The project is bind mounted, so files created by the image's user would be owned by its ID on the host.
+		// Docker Desktop maps file ownership itself, so this is only needed on Linux.
+		runOptions.User = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+		// Your user doesn't exist in the image, so it doesn't have a home directory to write caches to
+		runOptions.Env = append(runOptions.Env, "HOME=/tmp")
+	}
+
 	for _, portString := range runPorts {
 		port, err := strconv.Atoi(portString)
 		if err != nil {
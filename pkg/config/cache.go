@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Types of build cache backends, set with the type of an item in build.cache.from or build.cache.to
+const (
+	// CacheTypeRegistry stores the cache as an image in a registry
+	CacheTypeRegistry = "registry"
+	// CacheTypeLocal stores the cache in a local directory
+	CacheTypeLocal = "local"
+	// CacheTypeInline stores the cache in the image that is built. It can only be exported, and is imported
+	// with a registry backend that refers to the image.
+	CacheTypeInline = "inline"
+	// CacheTypeGHA stores the cache in the GitHub Actions cache
+	CacheTypeGHA = "gha"
+)
+
+// Cache is where the build cache is imported from and exported to. When it isn't set, the cache is imported
+// from the latest tag of the image.
+type Cache struct {
+	From []CacheBackend `json:"from,omitempty" yaml:"from"`
+	To   []CacheBackend `json:"to,omitempty" yaml:"to"`
+	// Branch namespaces the cache by git branch, so builds of a branch don't overwrite each other's cache.
+	// The cache of the default namespace is imported too, so new branches start from it.
+	Branch bool `json:"branch,omitempty" yaml:"branch"`
+}
+
+// CacheBackend is a place the build cache is stored
+type CacheBackend struct {
+	Type string `json:"type" yaml:"type"`
+	// Ref is the image the cache is stored in, for the registry backend
+	Ref string `json:"ref,omitempty" yaml:"ref"`
+	// Dir is the directory the cache is stored in, for the local backend
+	Dir string `json:"dir,omitempty" yaml:"dir"`
+	// Scope is the name of the cache, for the gha backend
+	Scope string `json:"scope,omitempty" yaml:"scope"`
+	// Mode is min to export the layers of the image, or max to export the layers of every stage
+	Mode string `json:"mode,omitempty" yaml:"mode"`
+}
+
+var invalidCacheNamespaceChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func (c *Cache) validate() error {
+	for i, backend := range c.From {
+		if err := backend.validate(fmt.Sprintf("cache.from[%d]", i), false); err != nil {
+			return err
+		}
+	}
+	for i, backend := range c.To {
+		if err := backend.validate(fmt.Sprintf("cache.to[%d]", i), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b CacheBackend) validate(field string, export bool) error {
+	switch b.Type {
+	case CacheTypeRegistry:
+		if b.Ref == "" {
+			return fmt.Errorf("%s in cog.yaml has type registry, so it needs a ref, like r8.im/user/model:buildcache", field)
+		}
+	case CacheTypeLocal:
+		if b.Dir == "" {
+			return fmt.Errorf("%s in cog.yaml has type local, so it needs a dir", field)
+		}
+	case CacheTypeInline:
+		if !export {
+			return fmt.Errorf("%s in cog.yaml has type inline, which can only be exported. To import an inline cache, use type registry with the image as the ref", field)
+		}
+		if b.Mode == "max" {
+			return fmt.Errorf("%s in cog.yaml has type inline, which doesn't support mode max", field)
+		}
+	case CacheTypeGHA:
+	default:
+		return fmt.Errorf("%s in cog.yaml has type %q. The type must be one of: registry, local, inline, gha", field, b.Type)
+	}
+	if b.Mode != "" {
+		if !export {
+			return fmt.Errorf("%s in cog.yaml has a mode, but mode only applies to exporting the cache", field)
+		}
+		if b.Mode != "min" && b.Mode != "max" {
+			return fmt.Errorf("%s in cog.yaml has mode %q. The mode must be min or max", field, b.Mode)
+		}
+	}
+	return nil
+}
+
+// ImportArg returns the backend in the format of buildx's --cache-from, in the namespace for a branch.
+// An empty branch is the default namespace.
+func (b CacheBackend) ImportArg(branch string) string {
+	switch b.Type {
+	case CacheTypeRegistry:
+		return "type=registry,ref=" + b.namespacedRef(branch)
+	case CacheTypeLocal:
+		return "type=local,src=" + b.namespacedDir(branch)
+	case CacheTypeGHA:
+		return "type=gha,scope=" + b.namespacedScope(branch)
+	}
+	return ""
+}
+
+// ExportArg returns the backend in the format of buildx's --cache-to, in the namespace for a branch.
+// An empty branch is the default namespace.
+func (b CacheBackend) ExportArg(branch string) string {
+	arg := ""
+	switch b.Type {
+	case CacheTypeRegistry:
+		arg = "type=registry,ref=" + b.namespacedRef(branch)
+	case CacheTypeLocal:
+		arg = "type=local,dest=" + b.namespacedDir(branch)
+	case CacheTypeInline:
+		return "type=inline"
+	case CacheTypeGHA:
+		arg = "type=gha,scope=" + b.namespacedScope(branch)
+	}
+	if b.Mode != "" {
+		arg += ",mode=" + b.Mode
+	}
+	return arg
+}
+
+// CacheNamespace turns a git branch into a name that can be used in image tags, directories and scopes
+func CacheNamespace(branch string) string {
+	namespace := strings.Trim(invalidCacheNamespaceChars.ReplaceAllString(branch, "-"), "-.")
+	// Tags can be 128 characters long, so leave room for the tag the namespace is added to
+	if len(namespace) > 64 {
+		namespace = namespace[:64]
+	}
+	return namespace
+}
+
+func (b CacheBackend) namespacedRef(branch string) string {
+	if branch == "" {
+		return b.Ref
+	}
+	// The tag is after the last colon, unless that colon is part of a registry host's port
+	lastColon := strings.LastIndex(b.Ref, ":")
+	if lastColon > strings.LastIndex(b.Ref, "/") {
+		return b.Ref + "-" + CacheNamespace(branch)
+	}
+	return b.Ref + ":" + CacheNamespace(branch)
+}
+
+func (b CacheBackend) namespacedDir(branch string) string {
+	if branch == "" {
+		return b.Dir
+	}
+	return path.Join(b.Dir, CacheNamespace(branch))
+}
+
+func (b CacheBackend) namespacedScope(branch string) string {
+	scope := b.Scope
+	if scope == "" {
+		// The default scope of the gha backend
+		scope = "buildkit"
+	}
+	if branch == "" {
+		return scope
+	}
+	return scope + "-" + CacheNamespace(branch)
+}
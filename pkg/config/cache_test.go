@@ -0,0 +1,113 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheBackendArgs(t *testing.T) {
+	for _, tt := range []struct {
+		backend CacheBackend
+		branch  string
+		from    string
+		to      string
+	}{
+		{
+			backend: CacheBackend{Type: CacheTypeRegistry, Ref: "r8.im/user/model:buildcache", Mode: "max"},
+			from:    "type=registry,ref=r8.im/user/model:buildcache",
+			to:      "type=registry,ref=r8.im/user/model:buildcache,mode=max",
+		},
+		{
+			backend: CacheBackend{Type: CacheTypeRegistry, Ref: "r8.im/user/model:buildcache"},
+			branch:  "feature/new-model",
+			from:    "type=registry,ref=r8.im/user/model:buildcache-feature-new-model",
+			to:      "type=registry,ref=r8.im/user/model:buildcache-feature-new-model",
+		},
+		{
+			backend: CacheBackend{Type: CacheTypeRegistry, Ref: "localhost:5000/cache"},
+			branch:  "main",
+			from:    "type=registry,ref=localhost:5000/cache:main",
+			to:      "type=registry,ref=localhost:5000/cache:main",
+		},
+		{
+			backend: CacheBackend{Type: CacheTypeLocal, Dir: ".cache/build"},
+			branch:  "main",
+			from:    "type=local,src=.cache/build/main",
+			to:      "type=local,dest=.cache/build/main",
+		},
+		{
+			backend: CacheBackend{Type: CacheTypeInline},
+			branch:  "main",
+			to:      "type=inline",
+		},
+		{
+			backend: CacheBackend{Type: CacheTypeGHA, Mode: "max"},
+			branch:  "main",
+			from:    "type=gha,scope=buildkit-main",
+			to:      "type=gha,scope=buildkit-main,mode=max",
+		},
+		{
+			backend: CacheBackend{Type: CacheTypeGHA, Scope: "model"},
+			from:    "type=gha,scope=model",
+			to:      "type=gha,scope=model",
+		},
+	} {
+		require.Equal(t, tt.from, tt.backend.ImportArg(tt.branch))
+		require.Equal(t, tt.to, tt.backend.ExportArg(tt.branch))
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+	require.Equal(t, "main", CacheNamespace("main"))
+	require.Equal(t, "user-fix-bug_2", CacheNamespace("user/fix:bug_2"))
+	require.Equal(t, "release-1.0", CacheNamespace("-release 1.0."))
+	require.Len(t, CacheNamespace(strings.Repeat("a", 100)), 64)
+}
+
+func TestValidateCache(t *testing.T) {
+	for _, tt := range []struct {
+		cache *Cache
+		err   string
+	}{
+		{
+			cache: &Cache{
+				From: []CacheBackend{{Type: CacheTypeRegistry, Ref: "r8.im/user/model:buildcache"}},
+				To:   []CacheBackend{{Type: CacheTypeInline}, {Type: CacheTypeGHA, Mode: "max"}},
+			},
+		},
+		{
+			cache: &Cache{From: []CacheBackend{{Type: CacheTypeRegistry}}},
+			err:   "cache.from[0] in cog.yaml has type registry, so it needs a ref",
+		},
+		{
+			cache: &Cache{To: []CacheBackend{{Type: CacheTypeLocal}}},
+			err:   "cache.to[0] in cog.yaml has type local, so it needs a dir",
+		},
+		{
+			cache: &Cache{From: []CacheBackend{{Type: CacheTypeInline}}},
+			err:   "cache.from[0] in cog.yaml has type inline, which can only be exported",
+		},
+		{
+			cache: &Cache{To: []CacheBackend{{Type: CacheTypeInline, Mode: "max"}}},
+			err:   "cache.to[0] in cog.yaml has type inline, which doesn't support mode max",
+		},
+		{
+			cache: &Cache{From: []CacheBackend{{Type: CacheTypeGHA, Mode: "max"}}},
+			err:   "cache.from[0] in cog.yaml has a mode, but mode only applies to exporting the cache",
+		},
+		{
+			cache: &Cache{To: []CacheBackend{{Type: "s3"}}},
+			err:   `cache.to[0] in cog.yaml has type "s3". The type must be one of: registry, local, inline, gha`,
+		},
+	} {
+		config := &Config{Build: &Build{PythonVersion: "3.11", Cache: tt.cache}}
+		err := config.ValidateAndComplete("")
+		if tt.err == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, tt.err)
+		}
+	}
+}
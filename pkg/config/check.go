@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/files"
+	"github.com/replicate/cog/pkg/util/slices"
+	"github.com/replicate/cog/pkg/util/version"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is a single issue found when checking a project
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Field    string   `json:"field,omitempty"`
+	Message  string   `json:"message"`
+}
+
+type CheckResult struct {
+	Problems []Problem `json:"problems"`
+}
+
+func (r *CheckResult) add(severity Severity, field string, format string, v ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Severity: severity,
+		Field:    field,
+		Message:  fmt.Sprintf(format, v...),
+	})
+}
+
+func (r *CheckResult) addError(field string, err error) {
+	// ValidateAndComplete joins all its errors, so unpack them to report each one separately
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			r.addError(field, e)
+		}
+		return
+	}
+	r.add(SeverityError, field, "%s", err)
+}
+
+// Count returns the number of problems with the given severity
+func (r *CheckResult) Count(severity Severity) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Check validates the project in projectDir without building it.
+//
+// Problems with the project are returned in the result. An error is only returned if
+// the check itself could not be run.
+func Check(projectDir string) (*CheckResult, error) {
+	result := &CheckResult{Problems: []Problem{}}
+
+	configPath := path.Join(projectDir, global.ConfigFilename)
+	exists, err := files.Exists(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s does not exist in %s. Are you in the right directory?", global.ConfigFilename, projectDir)
+	}
+
+	config, err := loadConfigFromFile(configPath)
+	if err != nil {
+		var validationErr validationError
+		if errors.As(err, &validationErr) {
+			result.add(SeverityError, validationErr.parent.Field(), "%s", getDescription(validationErr))
+		} else {
+			result.add(SeverityError, "", "%s", err)
+		}
+		return result, nil
+	}
+
+	for _, deprecation := range config.Deprecations() {
+		result.add(SeverityWarning, "build.pre_install", "%s", deprecation)
+	}
+
+	if err := config.ValidateAndComplete(projectDir); err != nil {
+		result.addError("", err)
+	}
+
+	if config.Predict != "" {
+		checkPredictorReference(result, projectDir, "predict", config.Predict)
+	}
+	if config.Train != "" {
+		checkPredictorReference(result, projectDir, "train", config.Train)
+	}
+
+	checkPythonRequirements(result, config)
+	checkFrameworkCompatibility(result, config)
+
+	return result, nil
+}
+
+// checkPredictorReference checks that a reference in the form 'predict.py:Predictor' points
+// to a file that exists and that defines the named class or function
+func checkPredictorReference(result *CheckResult, projectDir string, field string, ref string) {
+	parts := strings.Split(ref, ":")
+	if len(parts) != 2 || !strings.HasSuffix(parts[0], ".py") || parts[1] == "" {
+		// ValidateAndComplete already reports a malformed 'predict', so only report 'train'
+		if field != "predict" {
+			result.add(SeverityError, field, "'%s' in cog.yaml must be in the form 'predict.py:Predictor'", field)
+		}
+		return
+	}
+	filename, name := parts[0], parts[1]
+
+	filePath := path.Join(projectDir, filename)
+	exists, err := files.Exists(filePath)
+	if err != nil {
+		result.add(SeverityError, field, "%s", err)
+		return
+	}
+	if !exists {
+		result.add(SeverityError, field, "%s references %s, which does not exist", field, filename)
+		return
+	}
+
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		result.add(SeverityError, field, "Failed to read %s: %s", filename, err)
+		return
+	}
+	definitionRe := regexp.MustCompile(`(?m)^(?:class\s+` + regexp.QuoteMeta(name) + `\b|(?:async\s+)?def\s+` + regexp.QuoteMeta(name) + `\s*\(|` + regexp.QuoteMeta(name) + `\s*=)`)
+	if !definitionRe.Match(contents) {
+		result.add(SeverityError, field, "%s references %s, but it is not defined at the top level of %s", field, name, filename)
+	}
+}
+
+// checkPythonRequirements flags lines that pip won't be able to parse, and packages
+// that aren't pinned to an exact version
+func checkPythonRequirements(result *CheckResult, config *Config) {
+	field := "build.python_packages"
+	if config.Build.PythonRequirements != "" {
+		field = "build.python_requirements"
+	}
+
+	for _, line := range config.Build.pythonRequirementsContent {
+		req, err := ParseRequirement(line)
+		if err != nil {
+			result.add(SeverityError, field, "%s", err)
+			continue
+		}
+		if req != nil && req.URL == "" && req.Version() == "" {
+			result.add(SeverityWarning, field, "%s is not pinned to a version. Pin it with 'package==version' to make builds reproducible", strings.TrimSpace(line))
+		}
+	}
+}
+
+// checkFrameworkCompatibility cross-checks pinned framework versions against the CUDA version.
+// Incompatible Python versions are already reported as errors by ValidateAndComplete.
+func checkFrameworkCompatibility(result *CheckResult, config *Config) {
+	if !config.Build.GPU || config.Build.CUDA == "" {
+		return
+	}
+
+	if torchVersion, ok := config.TorchVersion(); ok {
+		cudas, err := cudasFromTorch(torchVersion)
+		if err == nil && len(cudas) > 0 && len(slices.FilterString(cudas, func(cuda string) bool { return version.EqualMinor(cuda, config.Build.CUDA) })) == 0 {
+			result.add(SeverityWarning, "build.cuda", "torch==%s is not known to support CUDA %s. Compatible CUDA versions are: %s", torchVersion, config.Build.CUDA, strings.Join(cudas, ", "))
+		}
+	}
+
+	if tfVersion, ok := config.TensorFlowVersion(); ok {
+		tfCUDA, _, err := cudaFromTF(tfVersion)
+		if err == nil && tfCUDA != "" && !version.EqualMinor(tfCUDA, config.Build.CUDA) {
+			result.add(SeverityWarning, "build.cuda", "tensorflow==%s is not known to support CUDA %s. Compatible CUDA version is: %s", tfVersion, config.Build.CUDA, tfCUDA)
+		}
+	}
+}
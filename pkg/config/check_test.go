@@ -0,0 +1,155 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeProject(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte(contents), 0o644))
+	}
+	return dir
+}
+
+func TestCheckValidProject(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"cog.yaml": `build:
+  python_version: "3.11"
+  python_packages:
+    - "torch==2.3.1"
+predict: "predict.py:Predictor"
+`,
+		"predict.py": `from cog import BasePredictor
+
+class Predictor(BasePredictor):
+    def predict(self) -> str:
+        return "hello"
+`,
+	})
+
+	result, err := Check(dir)
+	require.NoError(t, err)
+	require.Empty(t, result.Problems)
+}
+
+func TestCheckMissingPredictor(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"cog.yaml": `build:
+  python_version: "3.11"
+predict: "predict.py:Predictor"
+train: "train.py:train"
+`,
+		"predict.py": `class SomethingElse:
+    pass
+`,
+	})
+
+	result, err := Check(dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Count(SeverityError))
+	require.Equal(t, "predict", result.Problems[0].Field)
+	require.Contains(t, result.Problems[0].Message, "Predictor, but it is not defined")
+	require.Equal(t, "train", result.Problems[1].Field)
+	require.Contains(t, result.Problems[1].Message, "train.py, which does not exist")
+}
+
+func TestCheckPredictFunction(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"cog.yaml": `build:
+  python_version: "3.11"
+train: "train.py:train"
+`,
+		"train.py": `def train(n: int) -> int:
+    return n
+`,
+	})
+
+	result, err := Check(dir)
+	require.NoError(t, err)
+	require.Empty(t, result.Problems)
+}
+
+func TestCheckUnpinnedAndUnparseableRequirements(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"cog.yaml": `build:
+  python_version: "3.11"
+  python_requirements: "requirements.txt"
+`,
+		"requirements.txt": `# comment
+--extra-index-url https://example.com
+foo==1.0.0
+bar>=2.0
+!!!
+`,
+	})
+
+	result, err := Check(dir)
+	require.NoError(t, err)
+	require.Equal(t, []Problem{{
+		Severity: SeverityWarning,
+		Field:    "build.python_requirements",
+		Message:  "bar>=2.0 is not pinned to a version. Pin it with 'package==version' to make builds reproducible",
+	}, {
+		Severity: SeverityError,
+		Field:    "build.python_requirements",
+		Message:  `Failed to parse Python requirement "!!!"`,
+	}}, result.Problems)
+}
+
+func TestCheckIncompatibleTorchCUDA(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"cog.yaml": `build:
+  gpu: true
+  cuda: "11.8"
+  python_version: "3.10"
+  python_packages:
+    - "torch==1.7.1"
+`,
+	})
+
+	result, err := Check(dir)
+	require.NoError(t, err)
+	require.Equal(t, 0, result.Count(SeverityError))
+	require.Equal(t, 1, result.Count(SeverityWarning))
+	require.Equal(t, "build.cuda", result.Problems[0].Field)
+	require.Contains(t, result.Problems[0].Message, "torch==1.7.1 is not known to support CUDA 11.8")
+}
+
+func TestCheckInvalidSchema(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"cog.yaml": `build:
+  python_version: "3.11"
+  python_requirements: 3
+`,
+	})
+
+	result, err := Check(dir)
+	require.NoError(t, err)
+	require.Len(t, result.Problems, 1)
+	require.Equal(t, SeverityError, result.Problems[0].Severity)
+	require.Equal(t, "build.python_requirements", result.Problems[0].Field)
+	require.Equal(t, "build.python_requirements must be a string", result.Problems[0].Message)
+}
+
+func TestCheckInvalidYAML(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"cog.yaml": `build:
+  gpu: "yes please"
+`,
+	})
+
+	result, err := Check(dir)
+	require.NoError(t, err)
+	require.Len(t, result.Problems, 1)
+	require.Contains(t, result.Problems[0].Message, "Failed to parse config yaml")
+}
+
+func TestCheckMissingConfig(t *testing.T) {
+	_, err := Check(t.TempDir())
+	require.Error(t, err)
+}
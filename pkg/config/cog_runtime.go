@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Values of build.cog_runtime
+const (
+	// CogRuntimeEmbedded installs the cog wheel embedded in the Cog CLI. It is the default.
+	CogRuntimeEmbedded = "embedded"
+	// CogRuntimePyPI is followed by version specifiers, like pypi==0.9.14, to install cog from PyPI
+	CogRuntimePyPI = "pypi"
+)
+
+// CogRuntimeSource is where the cog Python package that serves predictions is installed from
+type CogRuntimeSource struct {
+	// Requirement is set to install cog from PyPI
+	Requirement *Requirement
+	// Path is set to install cog from a wheel or a directory in the project
+	Path string
+}
+
+// Embedded returns whether the cog wheel embedded in the Cog CLI is installed
+func (s CogRuntimeSource) Embedded() bool {
+	return s.Requirement == nil && s.Path == ""
+}
+
+// CogRuntimeSource returns where cog is installed from, from build.cog_runtime
+func (c *Config) CogRuntimeSource() (CogRuntimeSource, error) {
+	runtime := strings.TrimSpace(c.Build.CogRuntime)
+	switch {
+	case runtime == "" || runtime == CogRuntimeEmbedded:
+		return CogRuntimeSource{}, nil
+	case strings.HasPrefix(runtime, CogRuntimePyPI) && strings.ContainsAny(runtime[len(CogRuntimePyPI):], "=<>!~"):
+		specifiers := strings.TrimSpace(runtime[len(CogRuntimePyPI):])
+		req, err := ParseRequirement("cog" + specifiers)
+		if err != nil || req == nil || req.Name != "cog" {
+			return CogRuntimeSource{}, fmt.Errorf("cog_runtime in cog.yaml has invalid version specifiers %q. Pin a version, like pypi==0.9.14", specifiers)
+		}
+		return CogRuntimeSource{Requirement: req}, nil
+	case runtime == CogRuntimePyPI:
+		return CogRuntimeSource{}, fmt.Errorf("cog_runtime: pypi in cog.yaml needs a version, like pypi==0.9.14")
+	default:
+		return CogRuntimeSource{Path: filepath.ToSlash(filepath.Clean(runtime))}, nil
+	}
+}
+
+// validateCogRuntime checks build.cog_runtime, and that a path points to a wheel or a directory in the project
+func (c *Config) validateCogRuntime(projectDir string) error {
+	source, err := c.CogRuntimeSource()
+	if err != nil || source.Path == "" {
+		return err
+	}
+	if !filepath.IsLocal(source.Path) {
+		return fmt.Errorf("cog_runtime in cog.yaml must be embedded, pypi==<version>, or a path in your project, not %q", c.Build.CogRuntime)
+	}
+	info, err := os.Stat(filepath.Join(projectDir, source.Path))
+	if err != nil {
+		return fmt.Errorf("Failed to read cog_runtime: %w", err)
+	}
+	if !info.IsDir() && !strings.HasSuffix(source.Path, ".whl") {
+		return fmt.Errorf("cog_runtime in cog.yaml must be a wheel or a directory with a Python package, but %s isn't a .whl file", source.Path)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCogRuntimeSource(t *testing.T) {
+	config := &Config{Build: &Build{}}
+	source, err := config.CogRuntimeSource()
+	require.NoError(t, err)
+	require.True(t, source.Embedded())
+
+	config.Build.CogRuntime = "embedded"
+	source, err = config.CogRuntimeSource()
+	require.NoError(t, err)
+	require.True(t, source.Embedded())
+
+	config.Build.CogRuntime = "pypi==0.9.14"
+	source, err = config.CogRuntimeSource()
+	require.NoError(t, err)
+	require.Equal(t, "cog==0.9.14", source.Requirement.String())
+
+	config.Build.CogRuntime = "pypi"
+	_, err = config.CogRuntimeSource()
+	require.ErrorContains(t, err, "cog_runtime: pypi in cog.yaml needs a version")
+
+	config.Build.CogRuntime = "./vendor/cog/"
+	source, err = config.CogRuntimeSource()
+	require.NoError(t, err)
+	require.Equal(t, "vendor/cog", source.Path)
+}
+
+func TestValidateCogRuntime(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cog.tar.gz"), []byte{}, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cog-0.9.14-py3-none-any.whl"), []byte{}, 0o644))
+
+	for _, tt := range []struct {
+		runtime string
+		err     string
+	}{
+		{"cog-0.9.14-py3-none-any.whl", ""},
+		{".", ""},
+		{"../cog-0.9.14-py3-none-any.whl", "cog_runtime in cog.yaml must be embedded, pypi==<version>, or a path in your project"},
+		{"cog.tar.gz", "cog.tar.gz isn't a .whl file"},
+		{"missing.whl", "Failed to read cog_runtime"},
+	} {
+		config := &Config{Build: &Build{PythonVersion: "3.11", CogRuntime: tt.runtime}}
+		err := config.ValidateAndComplete(dir)
+		if tt.err == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, tt.err)
+		}
+	}
+}
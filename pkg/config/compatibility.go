@@ -16,7 +16,6 @@ import (
 	"github.com/replicate/cog/pkg/util/version"
 )
 
-// TODO(andreas): check tf/py versions. tf 1.5.0 didn't install on py 3.8
 // TODO(andreas): support more tf versions. No matching tensorflow CPU package for version 1.15.4, etc.
 // TODO(andreas): allow user to install versions that aren't compatible
 // TODO(andreas): allow user to install tf cpu package on gpu
@@ -37,8 +36,14 @@ func (compat *TFCompatibility) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, c); err != nil {
 		return err
 	}
-	cuda := version.MustVersion(c.CUDA)
-	cuDNN := version.MustVersion(c.CuDNN)
+	cuda, err := version.NewVersion(c.CUDA)
+	if err != nil {
+		return fmt.Errorf("Invalid CUDA version for tensorflow %s: %w", c.TF, err)
+	}
+	cuDNN, err := version.NewVersion(c.CuDNN)
+	if err != nil {
+		return fmt.Errorf("Invalid cuDNN version for tensorflow %s: %w", c.TF, err)
+	}
 	compat.TF = c.TF
 	compat.TFCPUPackage = c.TFCPUPackage
 	compat.TFGPUPackage = c.TFGPUPackage
@@ -70,6 +75,11 @@ func (c *TorchCompatibility) TorchvisionVersion() string {
 	return parts[0]
 }
 
+func (c *TorchCompatibility) TorchaudioVersion() string {
+	parts := strings.Split(c.Torchaudio, "+")
+	return parts[0]
+}
+
 type CUDABaseImage struct {
 	Tag     string
 	CUDA    string
@@ -82,17 +92,24 @@ func (i *CUDABaseImage) ImageTag() string {
 	return "nvidia/cuda:" + i.Tag
 }
 
-//go:generate go run ../../tools/compatgen/main.go cuda -o cuda_base_images.json
+// RuntimeImageTag returns the runtime variant of the image, which has the CUDA and cuDNN libraries but not
+// the compilers and headers of the devel variant
+func (i *CUDABaseImage) RuntimeImageTag() string {
+	if !i.IsDevel {
+		return i.ImageTag()
+	}
+	return "nvidia/cuda:" + strings.Replace(i.Tag, "-devel-", "-runtime-", 1)
+}
+
+//go:generate go run ../../tools/generate_compatibility_matrices -cuda-images-output cuda_base_images.json -tf-output tf_compatibility_matrix.json -torch-output torch_compatibility_matrix.json
 //go:embed cuda_base_images.json
 var cudaBaseImagesData []byte
 var CUDABaseImages []CUDABaseImage
 
-//go:generate go run ../../tools/compatgen/main.go tensorflow -o tf_compatibility_matrix.json
 //go:embed tf_compatibility_matrix.json
 var tfCompatibilityMatrixData []byte
 var TFCompatibilityMatrix []TFCompatibility
 
-//go:generate go run ../../tools/compatgen/main.go torch -o torch_compatibility_matrix.json
 //go:embed torch_compatibility_matrix.json
 var torchCompatibilityMatrixData []byte
 var TorchCompatibilityMatrix []TorchCompatibility
@@ -103,29 +120,43 @@ var TorchCompatibilityMatrix []TorchCompatibility
 var TorchMinorCompatibilityMatrix []TorchCompatibility
 
 func init() {
-	if err := json.Unmarshal(cudaBaseImagesData, &CUDABaseImages); err != nil {
+	matrices := compatibilityMatrices{}
+	if err := json.Unmarshal(cudaBaseImagesData, &matrices.CUDABaseImages); err != nil {
 		console.Fatalf("Failed to load embedded CUDA base images: %s", err)
 	}
 
-	if err := json.Unmarshal(tfCompatibilityMatrixData, &TFCompatibilityMatrix); err != nil {
+	if err := json.Unmarshal(tfCompatibilityMatrixData, &matrices.TF); err != nil {
 		console.Fatalf("Failed to load embedded Tensorflow compatibility matrix: %s", err)
 	}
 
-	var torchCompatibilityMatrix []TorchCompatibility
-	if err := json.Unmarshal(torchCompatibilityMatrixData, &torchCompatibilityMatrix); err != nil {
+	if err := json.Unmarshal(torchCompatibilityMatrixData, &matrices.Torch); err != nil {
 		console.Fatalf("Failed to load embedded PyTorch compatibility matrix: %s", err)
 	}
-	filteredTorchCompatibilityMatrix := []TorchCompatibility{}
-	for _, compat := range torchCompatibilityMatrix {
-		for _, cudaBaseImage := range CUDABaseImages {
+
+	if dir, err := CompatibilityOverrideDir(); err == nil {
+		if err := matrices.mergeOverrides(dir); err != nil {
+			console.Warnf("Ignoring compatibility matrix overrides: %s", err)
+		}
+	}
+
+	CUDABaseImages = matrices.CUDABaseImages
+	TFCompatibilityMatrix = matrices.TF
+	TorchCompatibilityMatrix = filterTorchCompatibilityMatrix(matrices.Torch, CUDABaseImages)
+	TorchMinorCompatibilityMatrix = generateTorchMinorVersionCompatibilityMatrix(TorchCompatibilityMatrix)
+}
+
+// filterTorchCompatibilityMatrix removes the torch builds for CUDA versions that don't have a base image
+func filterTorchCompatibilityMatrix(matrix []TorchCompatibility, cudaBaseImages []CUDABaseImage) []TorchCompatibility {
+	filtered := []TorchCompatibility{}
+	for _, compat := range matrix {
+		for _, cudaBaseImage := range cudaBaseImages {
 			if compat.CUDA == nil || version.Matches(*compat.CUDA, cudaBaseImage.CUDA) {
-				filteredTorchCompatibilityMatrix = append(filteredTorchCompatibilityMatrix, compat)
+				filtered = append(filtered, compat)
 				break
 			}
 		}
 	}
-	TorchCompatibilityMatrix = filteredTorchCompatibilityMatrix
-	TorchMinorCompatibilityMatrix = generateTorchMinorVersionCompatibilityMatrix(TorchCompatibilityMatrix)
+	return filtered
 }
 
 func generateTorchMinorVersionCompatibilityMatrix(matrix []TorchCompatibility) []TorchCompatibility {
@@ -191,6 +222,115 @@ func cudaFromTF(ver string) (cuda string, cuDNN string, err error) {
 	return "", "", nil
 }
 
+// pythonsFromTorch returns the Python versions supported by a torch version,
+// or nil if Cog doesn't know about that version
+func pythonsFromTorch(ver string) []string {
+	pythons := []string{}
+	for _, compat := range TorchCompatibilityMatrix {
+		if ver == compat.TorchVersion() {
+			pythons = appendMissing(pythons, compat.Pythons...)
+		}
+	}
+	if len(pythons) == 0 {
+		for _, compat := range TorchMinorCompatibilityMatrix {
+			if ver == compat.TorchVersion() {
+				pythons = appendMissing(pythons, compat.Pythons...)
+			}
+		}
+	}
+	if len(pythons) == 0 {
+		return nil
+	}
+	sortVersions(pythons)
+	return pythons
+}
+
+// pythonsFromTF returns the Python versions supported by a tensorflow version,
+// or nil if Cog doesn't know about that version
+func pythonsFromTF(ver string) []string {
+	for _, compat := range TFCompatibilityMatrix {
+		if ver == compat.TF {
+			pythons := appendMissing([]string{}, compat.Pythons...)
+			sortVersions(pythons)
+			return pythons
+		}
+	}
+	return nil
+}
+
+// latestTorchForPython returns the most recent torch version that supports the given Python version
+func latestTorchForPython(python string) string {
+	latest := ""
+	for _, compat := range TorchCompatibilityMatrix {
+		if sliceContains(compat.Pythons, python) && (latest == "" || version.Greater(compat.TorchVersion(), latest)) {
+			latest = compat.TorchVersion()
+		}
+	}
+	return latest
+}
+
+// latestTFForPython returns the most recent tensorflow version that supports the given Python version
+func latestTFForPython(python string) string {
+	latest := ""
+	for _, compat := range TFCompatibilityMatrix {
+		if sliceContains(compat.Pythons, python) && (latest == "" || version.Greater(compat.TF, latest)) {
+			latest = compat.TF
+		}
+	}
+	return latest
+}
+
+// torchVersions returns all the torch versions that Cog knows about
+func torchVersions() []string {
+	versions := []string{}
+	for _, compat := range TorchCompatibilityMatrix {
+		versions = appendMissing(versions, compat.TorchVersion())
+	}
+	return versions
+}
+
+// torchvisionVersions returns all the torchvision versions that Cog knows about
+func torchvisionVersions() []string {
+	versions := []string{}
+	for _, compat := range TorchCompatibilityMatrix {
+		if compat.Torchvision != "" {
+			versions = appendMissing(versions, compat.TorchvisionVersion())
+		}
+	}
+	return versions
+}
+
+// tfVersions returns all the tensorflow versions that Cog knows about
+func tfVersions() []string {
+	versions := []string{}
+	for _, compat := range TFCompatibilityMatrix {
+		versions = appendMissing(versions, compat.TF)
+	}
+	return versions
+}
+
+// torchvisionsFromTorch returns the torchvision versions that were released alongside a torch version
+func torchvisionsFromTorch(ver string) []string {
+	torchvisions := []string{}
+	for _, compat := range TorchCompatibilityMatrix {
+		if ver == compat.TorchVersion() && compat.Torchvision != "" {
+			torchvisions = appendMissing(torchvisions, compat.TorchvisionVersion())
+		}
+	}
+	return torchvisions
+}
+
+// torchaudiosFromTorch returns the torchaudio versions that were released alongside a torch version
+func torchaudiosFromTorch(ver string) []string {
+	torchaudios := []string{}
+	for _, compat := range TorchCompatibilityMatrix {
+		if ver == compat.TorchVersion() && compat.Torchaudio != "" {
+			torchaudios = appendMissing(torchaudios, compat.TorchaudioVersion())
+		}
+	}
+	return torchaudios
+}
+
 func compatibleCuDNNsForCUDA(cuda string) []string {
 	cuDNNs := []string{}
 	for _, image := range CUDABaseImages {
@@ -255,28 +395,88 @@ func versionGreater(a string, b string) (bool, error) {
 	return aVer.Greater(bVer), nil
 }
 
-func CUDABaseImageFor(cuda string, cuDNN string, systemVersion string) (string, error) {
+// Values of build.cuda_variant
+const (
+	// CUDAVariantDevel has the compilers and headers to build CUDA extensions. It is the default.
+	CUDAVariantDevel = "devel"
+	// CUDAVariantRuntime only has the libraries needed to run CUDA programs, so images are smaller
+	CUDAVariantRuntime = "runtime"
+)
+
+// CUDABaseImageFor returns the base image for a CUDA and cuDNN version, Ubuntu release and variant. If the Ubuntu
+// release is empty, the newest one is picked.
+func CUDABaseImageFor(cuda string, cuDNN string, systemVersion string, variant string) (string, error) {
+	image, err := findCUDABaseImage(cuda, cuDNN, systemVersion, variant)
+	if err != nil {
+		return "", err
+	}
+	if variant == CUDAVariantRuntime {
+		return image.RuntimeImageTag(), nil
+	}
+	return image.ImageTag(), nil
+}
+
+// CUDARuntimeImageFor returns the runtime variant of the base image for a CUDA and cuDNN version
+func CUDARuntimeImageFor(cuda string, cuDNN string, systemVersion string) (string, error) {
+	return CUDABaseImageFor(cuda, cuDNN, systemVersion, CUDAVariantRuntime)
+}
+
+// findCUDABaseImage returns the image for the newest CUDA version that matches, preferring images of the variant.
+// Runtime images are made from the tags of devel images, so the devel variant needs a devel image but the runtime
+// variant can use either.
+func findCUDABaseImage(cuda string, cuDNN string, systemVersion string, variant string) (*CUDABaseImage, error) {
+	wantDevel := variant != CUDAVariantRuntime
 	var images []CUDABaseImage
 	for _, image := range CUDABaseImages {
-		if version.Matches(cuda, image.CUDA) && image.CuDNN == cuDNN && (systemVersion == "" || image.Ubuntu == systemVersion) {
+		if !version.Matches(cuda, image.CUDA) || image.CuDNN != cuDNN || (wantDevel && !image.IsDevel) {
+			continue
+		}
+		if systemVersion == "" || image.Ubuntu == systemVersion {
 			images = append(images, image)
 		}
 	}
 	if len(images) == 0 {
-		return "", fmt.Errorf("No matching base image for CUDA %s and CuDNN %s", cuda, cuDNN)
+		if systemVersion != "" {
+			if err := validateSystemVersion(cuda, cuDNN, systemVersion); err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("No matching base image for CUDA %s and CuDNN %s", cuda, cuDNN)
 	}
 
 	sort.Slice(images, func(i, j int) bool {
 		if images[i].CUDA != images[j].CUDA {
 			return version.MustVersion(images[i].CUDA).Greater(version.MustVersion(images[j].CUDA))
 		}
-		if images[i].Ubuntu == systemVersion {
-			return true
+		if images[i].Ubuntu != images[j].Ubuntu {
+			return images[i].Ubuntu > images[j].Ubuntu
 		}
-		return images[i].Ubuntu > images[j].Ubuntu
+		return images[i].IsDevel == wantDevel && images[j].IsDevel != wantDevel
 	})
 
-	return images[0].ImageTag(), nil
+	return &images[0], nil
+}
+
+// ubuntuVersionsForCUDA returns the Ubuntu releases there are base images for, for a CUDA and cuDNN version
+func ubuntuVersionsForCUDA(cuda string, cuDNN string) []string {
+	ubuntus := []string{}
+	for _, image := range CUDABaseImages {
+		if version.Matches(cuda, image.CUDA) && image.CuDNN == cuDNN && !sliceContains(ubuntus, image.Ubuntu) {
+			ubuntus = append(ubuntus, image.Ubuntu)
+		}
+	}
+	sort.Strings(ubuntus)
+	return ubuntus
+}
+
+// validateSystemVersion checks there is a base image for system_version with the CUDA and cuDNN version
+func validateSystemVersion(cuda string, cuDNN string, systemVersion string) error {
+	ubuntus := ubuntuVersionsForCUDA(cuda, cuDNN)
+	if len(ubuntus) == 0 || sliceContains(ubuntus, systemVersion) {
+		return nil
+	}
+	return fmt.Errorf(`system_version %s in cog.yaml isn't available for CUDA %s and cuDNN %s.
+Available Ubuntu releases are: %s`, systemVersion, cuda, cuDNN, strings.Join(ubuntus, ", "))
 }
 
 func tfGPUPackage(ver string, cuda string) (name string, cpuVersion string, err error) {
@@ -304,33 +504,9 @@ func torchCPUPackage(ver, goos, goarch string) (name, cpuVersion, findLinks, ext
 func torchGPUPackage(ver string, cuda string) (name, cpuVersion, findLinks, extraIndexURL string, err error) {
 	// find the torch package that has the requested torch version and the latest cuda version
 	// that is at most as high as the requested cuda version
-	var latest *TorchCompatibility
-	for _, compat := range TorchCompatibilityMatrix {
-		compat := compat
-		if compat.TorchVersion() != ver || compat.CUDA == nil {
-			continue
-		}
-		greater, err := versionGreater(*compat.CUDA, cuda)
-		if err != nil {
-			panic(fmt.Sprintf("Invalid CUDA version: %s", err))
-		}
-
-		if greater {
-			continue
-		}
-		if latest == nil {
-			latest = &compat
-		} else {
-			greater, err := versionGreater(*compat.CUDA, *latest.CUDA)
-			if err != nil {
-				// should never happen
-				panic(fmt.Sprintf("Invalid CUDA version: %s", err))
-			}
-			if greater {
-				latest = &compat
-			}
-		}
-	}
+	latest := latestTorchCompatibilityForCUDA(cuda, func(compat *TorchCompatibility) bool {
+		return compat.TorchVersion() == ver
+	})
 	if latest == nil {
 		// We've already warned user if they're doing something stupid in validateAndCompleteCUDA()
 		return "torch", ver, "", "", nil
@@ -353,10 +529,46 @@ func torchvisionGPUPackage(ver, cuda string) (name, cpuVersion, findLinks, extra
 	// find the torchvision package that has the requested
 	// torchvision version and the latest cuda version that is at
 	// most as high as the requested cuda version
+	latest := latestTorchCompatibilityForCUDA(cuda, func(compat *TorchCompatibility) bool {
+		return compat.TorchvisionVersion() == ver
+	})
+	if latest == nil {
+		// TODO: can we suggest a CUDA version known to be compatible?
+		console.Warnf("Cog doesn't know if CUDA %s is compatible with torchvision %s. This might cause CUDA problems.", cuda, ver)
+		return "torchvision", ver, "", "", nil
+	}
+
+	return "torchvision", latest.Torchvision, latest.FindLinks, latest.ExtraIndexURL, nil
+}
+
+func torchaudioCPUPackage(ver, goos, goarch string) (name, cpuVersion, findLinks, extraIndexURL string, err error) {
+	for _, compat := range TorchCompatibilityMatrix {
+		if compat.TorchaudioVersion() == ver && compat.CUDA == nil {
+			return "torchaudio", torchStripCPUSuffixForM1(compat.Torchaudio, goos, goarch), compat.FindLinks, compat.ExtraIndexURL, nil
+		}
+	}
+	// Fall back to just installing default version. Older torchaudio versions only have a single build.
+	return "torchaudio", ver, "", "", nil
+}
+
+func torchaudioGPUPackage(ver, cuda string) (name, cpuVersion, findLinks, extraIndexURL string, err error) {
+	latest := latestTorchCompatibilityForCUDA(cuda, func(compat *TorchCompatibility) bool {
+		return compat.TorchaudioVersion() == ver
+	})
+	if latest == nil {
+		return "torchaudio", ver, "", "", nil
+	}
+
+	return "torchaudio", latest.Torchaudio, latest.FindLinks, latest.ExtraIndexURL, nil
+}
+
+// latestTorchCompatibilityForCUDA returns the compatibility that matches and has the latest
+// CUDA version that is at most as high as the requested CUDA version
+func latestTorchCompatibilityForCUDA(cuda string, matches func(compat *TorchCompatibility) bool) *TorchCompatibility {
 	var latest *TorchCompatibility
 	for _, compat := range TorchCompatibilityMatrix {
 		compat := compat
-		if compat.TorchvisionVersion() != ver || compat.CUDA == nil {
+		if compat.CUDA == nil || !matches(&compat) {
 			continue
 		}
 		greater, err := versionGreater(*compat.CUDA, cuda)
@@ -379,21 +591,31 @@ func torchvisionGPUPackage(ver, cuda string) (name, cpuVersion, findLinks, extra
 			}
 		}
 	}
-	if latest == nil {
-		// TODO: can we suggest a CUDA version known to be compatible?
-		console.Warnf("Cog doesn't know if CUDA %s is compatible with torchvision %s. This might cause CUDA problems.", cuda, ver)
-		return "torchvision", ver, "", "", nil
-	}
-
-	return "torchvision", latest.Torchvision, latest.FindLinks, latest.ExtraIndexURL, nil
+	return latest
 }
 
 // aarch64 packages don't have +cpu suffix: https://download.pytorch.org/whl/torch_stable.html
 // TODO(andreas): clean up this hack by actually parsing the torch_stable.html list in the generator
 func torchStripCPUSuffixForM1(version string, goos string, goarch string) string {
 	// TODO(andreas): clean up this hack
-	if util.IsAppleSiliconMac(goos, goarch) {
+	if util.IsAppleSiliconMac(goos, goarch) || (goos == "linux" && goarch == "arm64") {
 		return strings.ReplaceAll(version, "+cpu", "")
 	}
 	return version
 }
+
+func appendMissing(slice []string, values ...string) []string {
+	for _, v := range values {
+		if !sliceContains(slice, v) {
+			slice = append(slice, v)
+		}
+	}
+	return slice
+}
+
+// sortVersions sorts versions in ascending order, so 3.9 comes before 3.10
+func sortVersions(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		return version.Greater(versions[j], versions[i])
+	})
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// CompatibilityDirEnvVar is the environment variable that sets the directory compatibility matrix overrides are loaded from
+const CompatibilityDirEnvVar = "COG_COMPAT_DIR"
+
+// The names of the compatibility matrix files, both embedded in Cog and in the override directory
+const (
+	cudaBaseImagesFilename     = "cuda_base_images.json"
+	tfCompatibilityFilename    = "tf_compatibility_matrix.json"
+	torchCompatibilityFilename = "torch_compatibility_matrix.json"
+)
+
+// compatibilityMatrices are the compatibility matrices as they are loaded, before the torch
+// matrix is filtered down to the CUDA versions that have base images
+type compatibilityMatrices struct {
+	CUDABaseImages []CUDABaseImage
+	TF             []TFCompatibility
+	Torch          []TorchCompatibility
+}
+
+// CompatibilityOverrideDir returns the directory that compatibility matrix overrides are loaded from:
+// $COG_COMPAT_DIR if it is set, otherwise ~/.config/cog/compat
+func CompatibilityOverrideDir() (string, error) {
+	if dir := os.Getenv(CompatibilityDirEnvVar); dir != "" {
+		return dir, nil
+	}
+	return homedir.Expand("~/.config/cog/compat")
+}
+
+// mergeOverrides merges any compatibility matrices in dir over the current ones. Rows in an
+// override replace the rows for the same version, and rows for new versions are added. If any
+// override can't be read, none of them are merged.
+//
+// This means new releases of torch and tensorflow can be used without upgrading Cog, by putting
+// the output of tools/generate_compatibility_matrices in the override directory.
+func (m *compatibilityMatrices) mergeOverrides(dir string) error {
+	var cudaBaseImages []CUDABaseImage
+	cudaFound, err := readOverride(dir, cudaBaseImagesFilename, &cudaBaseImages)
+	if err != nil {
+		return err
+	}
+	var tf []TFCompatibility
+	tfFound, err := readOverride(dir, tfCompatibilityFilename, &tf)
+	if err != nil {
+		return err
+	}
+	var torch []TorchCompatibility
+	torchFound, err := readOverride(dir, torchCompatibilityFilename, &torch)
+	if err != nil {
+		return err
+	}
+
+	if cudaFound {
+		m.CUDABaseImages = mergeRows(m.CUDABaseImages, cudaBaseImages, func(image CUDABaseImage) string {
+			return image.Tag
+		})
+	}
+	if tfFound {
+		m.TF = mergeRows(m.TF, tf, func(compat TFCompatibility) string {
+			return compat.TF
+		})
+	}
+	if torchFound {
+		m.Torch = mergeRows(m.Torch, torch, func(compat TorchCompatibility) string {
+			cuda := "cpu"
+			if compat.CUDA != nil {
+				cuda = *compat.CUDA
+			}
+			return compat.Torch + " " + cuda
+		})
+	}
+	return nil
+}
+
+// readOverride reads a compatibility matrix from dir, and returns false if it doesn't exist
+func readOverride(dir string, filename string, v any) (found bool, err error) {
+	filePath := filepath.Join(dir, filename)
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to read %s: %w", filePath, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("Failed to parse %s: %w", filePath, err)
+	}
+	console.Debugf("Loaded compatibility matrix override from %s", filePath)
+	return true, nil
+}
+
+// mergeRows returns the base rows with rows that have the same key replaced by the override, followed by
+// the override rows that are new
+func mergeRows[T any](base []T, override []T, key func(T) string) []T {
+	overrides := map[string]T{}
+	order := []string{}
+	for _, row := range override {
+		k := key(row)
+		if _, ok := overrides[k]; !ok {
+			order = append(order, k)
+		}
+		overrides[k] = row
+	}
+
+	merged := []T{}
+	replaced := map[string]bool{}
+	for _, row := range base {
+		k := key(row)
+		if override, ok := overrides[k]; ok {
+			row = override
+			replaced[k] = true
+		}
+		merged = append(merged, row)
+	}
+	for _, k := range order {
+		if !replaced[k] {
+			merged = append(merged, overrides[k])
+		}
+	}
+	return merged
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeCompatibilityOverrides(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, tfCompatibilityFilename), []byte(`[
+  {"TF": "2.16.1", "TFCPUPackage": "tensorflow==2.16.1", "TFGPUPackage": "tensorflow==2.16.1", "CUDA": "12.3", "CuDNN": "9.0", "Pythons": ["3.12"]},
+  {"TF": "2.99.0", "TFCPUPackage": "tensorflow==2.99.0", "TFGPUPackage": "tensorflow==2.99.0", "CUDA": "12.3", "CuDNN": "8.9", "Pythons": ["3.12"]}
+]`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, torchCompatibilityFilename), []byte(`[
+  {"Torch": "2.99.0+cu121", "Torchvision": "0.99.0+cu121", "Torchaudio": "2.99.0+cu121", "ExtraIndexURL": "https://download.pytorch.org/whl/cu121", "CUDA": "12.1", "Pythons": ["3.12"]}
+]`), 0o644))
+
+	matrices := compatibilityMatrices{
+		CUDABaseImages: CUDABaseImages,
+		TF: []TFCompatibility{
+			{TF: "2.16.1", CUDA: "12.3", CuDNN: "8", Pythons: []string{"3.9"}},
+			{TF: "2.15.0", CUDA: "12.2", CuDNN: "8", Pythons: []string{"3.9"}},
+		},
+		Torch: TorchCompatibilityMatrix,
+	}
+	require.NoError(t, matrices.mergeOverrides(dir))
+
+	// Existing rows are replaced in place, and new rows are added at the end
+	require.Len(t, matrices.TF, 3)
+	require.Equal(t, "2.16.1", matrices.TF[0].TF)
+	require.Equal(t, "9", matrices.TF[0].CuDNN)
+	require.Equal(t, []string{"3.12"}, matrices.TF[0].Pythons)
+	require.Equal(t, "2.15.0", matrices.TF[1].TF)
+	require.Equal(t, "2.99.0", matrices.TF[2].TF)
+
+	require.Len(t, matrices.Torch, len(TorchCompatibilityMatrix)+1)
+	require.Equal(t, "2.99.0+cu121", matrices.Torch[len(matrices.Torch)-1].Torch)
+
+	// Files that aren't in the override directory are left alone
+	require.Equal(t, CUDABaseImages, matrices.CUDABaseImages)
+}
+
+func TestMergeCompatibilityOverridesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, cudaBaseImagesFilename), []byte(`[{"Tag": "99.0-cudnn9-devel-ubuntu24.04", "CUDA": "99.0", "CuDNN": "9", "IsDevel": true, "Ubuntu": "24.04"}]`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, tfCompatibilityFilename), []byte(`[{"TF": "2.99.0", "CUDA": "twelve", "CuDNN": "8.9"}]`), 0o644))
+
+	// The valid CUDA base images aren't merged when the tensorflow matrix is invalid
+	matrices := compatibilityMatrices{CUDABaseImages: CUDABaseImages, TF: TFCompatibilityMatrix}
+	err := matrices.mergeOverrides(dir)
+	require.ErrorContains(t, err, tfCompatibilityFilename)
+	require.Equal(t, CUDABaseImages, matrices.CUDABaseImages)
+	require.Equal(t, TFCompatibilityMatrix, matrices.TF)
+}
+
+func TestMergeCompatibilityOverridesMissingDir(t *testing.T) {
+	matrices := compatibilityMatrices{TF: TFCompatibilityMatrix}
+	require.NoError(t, matrices.mergeOverrides(filepath.Join(t.TempDir(), "does-not-exist")))
+	require.Equal(t, TFCompatibilityMatrix, matrices.TF)
+}
+
+func TestCompatibilityOverrideDir(t *testing.T) {
+	t.Setenv(CompatibilityDirEnvVar, "/tmp/compat")
+	dir, err := CompatibilityOverrideDir()
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/compat", dir)
+
+	t.Setenv(CompatibilityDirEnvVar, "")
+	dir, err = CompatibilityOverrideDir()
+	require.NoError(t, err)
+	require.Equal(t, "compat", filepath.Base(dir))
+	require.Equal(t, "cog", filepath.Base(filepath.Dir(dir)))
+}
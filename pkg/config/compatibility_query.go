@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/replicate/cog/pkg/util/version"
+)
+
+// CompatibilityFilter narrows down the compatibility matrices. Versions match any version
+// they are a prefix of, so 2.1 matches 2.1.2. Empty fields match everything.
+type CompatibilityFilter struct {
+	Python string
+	Torch  string
+	TF     string
+	CUDA   string
+	CuDNN  string
+}
+
+func (f CompatibilityFilter) validate() error {
+	for _, v := range []struct {
+		name  string
+		value string
+	}{
+		{"Python", f.Python},
+		{"torch", f.Torch},
+		{"tensorflow", f.TF},
+		{"CUDA", f.CUDA},
+		{"cuDNN", f.CuDNN},
+	} {
+		if v.value == "" {
+			continue
+		}
+		if _, err := version.NewVersion(v.value); err != nil {
+			return fmt.Errorf("Invalid %s version %q: %w", v.name, v.value, err)
+		}
+	}
+	return nil
+}
+
+func (f CompatibilityFilter) matchesPython(pythons []string) bool {
+	if f.Python == "" {
+		return true
+	}
+	for _, python := range pythons {
+		if version.Matches(python, f.Python) || version.Matches(f.Python, python) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f CompatibilityFilter) matchesTorch(compat TorchCompatibility) bool {
+	if f.Torch != "" && !version.Matches(f.Torch, compat.TorchVersion()) {
+		return false
+	}
+	if f.CUDA != "" && (compat.CUDA == nil || !version.Matches(f.CUDA, *compat.CUDA)) {
+		return false
+	}
+	return f.matchesPython(compat.Pythons)
+}
+
+func (f CompatibilityFilter) matchesTF(compat TFCompatibility) bool {
+	if f.TF != "" && !version.Matches(f.TF, compat.TF) {
+		return false
+	}
+	if f.CUDA != "" && !version.Matches(f.CUDA, compat.CUDA) {
+		return false
+	}
+	if f.CuDNN != "" && !version.Matches(f.CuDNN, compat.CuDNN) {
+		return false
+	}
+	return f.matchesPython(compat.Pythons)
+}
+
+func (f CompatibilityFilter) matchesImage(image CUDABaseImage) bool {
+	if f.CUDA != "" && !version.Matches(f.CUDA, image.CUDA) {
+		return false
+	}
+	return f.CuDNN == "" || version.Matches(f.CuDNN, image.CuDNN)
+}
+
+// FilterTorchCompatibilities returns the rows of a torch compatibility matrix that match the filter
+func FilterTorchCompatibilities(matrix []TorchCompatibility, f CompatibilityFilter) ([]TorchCompatibility, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	compats := []TorchCompatibility{}
+	for _, compat := range matrix {
+		if f.matchesTorch(compat) {
+			compats = append(compats, compat)
+		}
+	}
+	return compats, nil
+}
+
+// FilterTFCompatibilities returns the rows of the tensorflow compatibility matrix that match the filter
+func FilterTFCompatibilities(f CompatibilityFilter) ([]TFCompatibility, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	compats := []TFCompatibility{}
+	for _, compat := range TFCompatibilityMatrix {
+		if f.matchesTF(compat) {
+			compats = append(compats, compat)
+		}
+	}
+	return compats, nil
+}
+
+// FilterCUDABaseImages returns the CUDA base images that match the filter's CUDA and cuDNN versions
+func FilterCUDABaseImages(f CompatibilityFilter) ([]CUDABaseImage, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	images := []CUDABaseImage{}
+	for _, image := range CUDABaseImages {
+		if f.matchesImage(image) {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// CUDACompatibility is a combination of CUDA and cuDNN that Cog has base images for, and the
+// torch and tensorflow versions that work with it
+type CUDACompatibility struct {
+	CUDA   string
+	CuDNN  string
+	Ubuntu []string
+	// Torch are the minor versions of torch that have builds for this CUDA version
+	Torch []string
+	TF    []string
+}
+
+// CUDACompatibilities returns the CUDA and cuDNN combinations that work with the filter, newest first.
+// If the filter has a torch or tensorflow version, only combinations that work with it are returned.
+func CUDACompatibilities(f CompatibilityFilter) ([]CUDACompatibility, error) {
+	images, err := FilterCUDABaseImages(f)
+	if err != nil {
+		return nil, err
+	}
+	torchCompats, err := FilterTorchCompatibilities(TorchCompatibilityMatrix, f)
+	if err != nil {
+		return nil, err
+	}
+	tfCompats, err := FilterTFCompatibilities(f)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []CUDACompatibility{}
+	indexes := map[[2]string]int{}
+	for _, image := range images {
+		key := [2]string{image.CUDA, image.CuDNN}
+		if i, ok := indexes[key]; ok {
+			result[i].Ubuntu = appendMissing(result[i].Ubuntu, image.Ubuntu)
+			continue
+		}
+
+		compat := CUDACompatibility{CUDA: image.CUDA, CuDNN: image.CuDNN, Ubuntu: []string{image.Ubuntu}, Torch: []string{}, TF: []string{}}
+		for _, torch := range torchCompats {
+			if torch.CUDA != nil && version.Matches(*torch.CUDA, image.CUDA) {
+				compat.Torch = appendMissing(compat.Torch, version.StripPatch(torch.TorchVersion()))
+			}
+		}
+		for _, tf := range tfCompats {
+			if version.Matches(tf.CUDA, image.CUDA) && tf.CuDNN == image.CuDNN {
+				compat.TF = appendMissing(compat.TF, tf.TF)
+			}
+		}
+		sortVersions(compat.Torch)
+		sortVersions(compat.TF)
+
+		switch {
+		case f.Torch != "" && len(compat.Torch) == 0:
+			continue
+		case f.TF != "" && len(compat.TF) == 0:
+			continue
+		case f.Python != "" && f.Torch == "" && f.TF == "" && len(compat.Torch) == 0 && len(compat.TF) == 0:
+			continue
+		}
+		indexes[key] = len(result)
+		result = append(result, compat)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].CUDA != result[j].CUDA {
+			return version.Greater(result[i].CUDA, result[j].CUDA)
+		}
+		return version.Greater(result[i].CuDNN, result[j].CuDNN)
+	})
+	return result, nil
+}
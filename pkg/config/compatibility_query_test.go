@@ -0,0 +1,98 @@
+package config
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/cog/pkg/util/version"
+)
+
+func TestFilterTorchCompatibilities(t *testing.T) {
+	compats, err := FilterTorchCompatibilities(TorchCompatibilityMatrix, CompatibilityFilter{Torch: "2.1", CUDA: "12.1"})
+	require.NoError(t, err)
+	require.NotEmpty(t, compats)
+	for _, compat := range compats {
+		require.Contains(t, []string{"2.1.0", "2.1.1", "2.1.2"}, compat.TorchVersion())
+		require.Equal(t, "12.1", *compat.CUDA)
+	}
+
+	compats, err = FilterTorchCompatibilities(TorchCompatibilityMatrix, CompatibilityFilter{Torch: "2.1.0", Python: "3.12"})
+	require.NoError(t, err)
+	require.Empty(t, compats)
+}
+
+func TestFilterTFCompatibilities(t *testing.T) {
+	compats, err := FilterTFCompatibilities(CompatibilityFilter{Python: "3.12"})
+	require.NoError(t, err)
+	require.NotEmpty(t, compats)
+	for _, compat := range compats {
+		require.Contains(t, compat.Pythons, "3.12")
+	}
+}
+
+func TestFilterCUDABaseImages(t *testing.T) {
+	images, err := FilterCUDABaseImages(CompatibilityFilter{CUDA: "12.3", CuDNN: "9"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"12.3.2-cudnn9-devel-ubuntu22.04", "12.3.2-cudnn9-devel-ubuntu20.04"}, imageTags(images))
+}
+
+func TestFilterInvalidVersion(t *testing.T) {
+	_, err := FilterCUDABaseImages(CompatibilityFilter{CUDA: "twelve"})
+	require.ErrorContains(t, err, `Invalid CUDA version "twelve"`)
+}
+
+func TestCUDACompatibilitiesForTorch(t *testing.T) {
+	compats, err := CUDACompatibilities(CompatibilityFilter{Torch: "2.1", Python: "3.11"})
+	require.NoError(t, err)
+	require.NotEmpty(t, compats)
+	for _, compat := range compats {
+		require.Contains(t, []string{"11.8", "12.1"}, compat.CUDA[:4])
+		require.Equal(t, []string{"2.1"}, compat.Torch)
+		require.Contains(t, compat.Ubuntu, "22.04")
+	}
+}
+
+func TestCUDACompatibilitiesOrder(t *testing.T) {
+	compats, err := CUDACompatibilities(CompatibilityFilter{})
+	require.NoError(t, err)
+	require.Greater(t, len(compats), 1)
+	for i := 1; i < len(compats); i++ {
+		previous, current := compats[i-1], compats[i]
+		if previous.CUDA == current.CUDA {
+			require.True(t, version.Greater(previous.CuDNN, current.CuDNN), "cuDNN %s should come before %s for CUDA %s", previous.CuDNN, current.CuDNN, current.CUDA)
+		} else {
+			require.True(t, version.Greater(previous.CUDA, current.CUDA), "CUDA %s should come before %s", previous.CUDA, current.CUDA)
+		}
+	}
+}
+
+func TestCUDACompatibilitiesForTensorflow(t *testing.T) {
+	compats, err := CUDACompatibilities(CompatibilityFilter{TF: "2.15.0"})
+	require.NoError(t, err)
+	require.Len(t, compats, 1)
+	require.Equal(t, "12.2.2", compats[0].CUDA)
+	require.Equal(t, "8", compats[0].CuDNN)
+	require.Equal(t, []string{"20.04", "22.04"}, sorted(compats[0].Ubuntu))
+	require.Equal(t, []string{"2.15.0"}, compats[0].TF)
+
+	// tensorflow 2.16.1 needs cuDNN 8 with CUDA 12.3, but the CUDA 12.3 images only have cuDNN 9
+	compats, err = CUDACompatibilities(CompatibilityFilter{TF: "2.16.1"})
+	require.NoError(t, err)
+	require.Empty(t, compats)
+}
+
+func sorted(s []string) []string {
+	s = append([]string{}, s...)
+	sort.Strings(s)
+	return s
+}
+
+func imageTags(images []CUDABaseImage) []string {
+	tags := []string{}
+	for _, image := range images {
+		tags = append(tags, image.Tag)
+	}
+	return tags
+}
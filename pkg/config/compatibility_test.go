@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -12,6 +13,16 @@ func TestLatestCuDNNForCUDA(t *testing.T) {
 	require.Equal(t, "8", actual)
 }
 
+func TestCUDARuntimeImageFor(t *testing.T) {
+	devel, err := CUDABaseImageFor("11.8", "8", "", CUDAVariantDevel)
+	require.NoError(t, err)
+	require.Contains(t, devel, "-devel-")
+
+	actual, err := CUDARuntimeImageFor("11.8", "8", "")
+	require.NoError(t, err)
+	require.Equal(t, strings.Replace(devel, "-devel-", "-runtime-", 1), actual)
+}
+
 func TestGenerateTorchMinorVersionCompatibilityMatrix(t *testing.T) {
 	matrix := []TorchCompatibility{{
 		Torch:   "2.0.0",
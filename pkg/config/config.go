@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
-	"regexp"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 
@@ -21,12 +23,29 @@ import (
 var (
 	BuildSourceEpochTimestamp int64 = -1
 	BuildXCachePath           string
+	// BuildReproducible pins the images a build uses to digests, so building the same source makes the same image
+	BuildReproducible bool
+	// BuildCacheFrom and BuildCacheTo override build.cache.from and build.cache.to, in the format of buildx's
+	// --cache-from and --cache-to
+	BuildCacheFrom []string
+	BuildCacheTo   []string
+	// BuildNoCache builds without importing a cache
+	BuildNoCache bool
 )
 
 // TODO(andreas): support conda packages
 // TODO(andreas): support dockerfiles
 // TODO(andreas): custom cpu/gpu installs
-// TODO(andreas): suggest valid torchvision versions (e.g. if the user wants to use 0.8.0, suggest 0.8.1)
+
+// How Python is installed in GPU images, set with build.python_install
+const (
+	// PythonInstallPyenv compiles Python from source with pyenv. It is the default.
+	PythonInstallPyenv = "pyenv"
+	// PythonInstallStandalone installs a prebuilt Python from python-build-standalone
+	PythonInstallStandalone = "standalone"
+	// PythonInstallUV installs a prebuilt Python with uv
+	PythonInstallUV = "uv"
+)
 
 const (
 	MinimumMajorPythonVersion int = 3
@@ -35,28 +54,48 @@ const (
 )
 
 type RunItem struct {
-	Command string `json:"command,omitempty" yaml:"command"`
-	Mounts  []struct {
-		Type   string `json:"type,omitempty" yaml:"type"`
-		ID     string `json:"id,omitempty" yaml:"id"`
-		Target string `json:"target,omitempty" yaml:"target"`
-	} `json:"mounts,omitempty" yaml:"mounts"`
+	Command string            `json:"command,omitempty" yaml:"command"`
+	Mounts  []RunMount        `json:"mounts,omitempty" yaml:"mounts"`
+	Env     map[string]string `json:"env,omitempty" yaml:"env"`
+	Workdir string            `json:"workdir,omitempty" yaml:"workdir"`
+	Stage   string            `json:"stage,omitempty" yaml:"stage"`
 }
 
 type Build struct {
-	GPU                bool      `json:"gpu,omitempty" yaml:"gpu"`
-	PythonVersion      string    `json:"python_version,omitempty" yaml:"python_version"`
-	PythonRequirements string    `json:"python_requirements,omitempty" yaml:"python_requirements"`
-	PythonPackages     []string  `json:"python_packages,omitempty" yaml:"python_packages"` // Deprecated, but included for backwards compatibility
-	Run                []RunItem `json:"run,omitempty" yaml:"run"`
-	SystemPackages     []string  `json:"system_packages,omitempty" yaml:"system_packages"`
-	PreInstall         []string  `json:"pre_install,omitempty" yaml:"pre_install"` // Deprecated, but included for backwards compatibility
-	CUDA               string    `json:"cuda,omitempty" yaml:"cuda"`
-	CuDNN              string    `json:"cudnn,omitempty" yaml:"cudnn"`
+	GPU                bool            `json:"gpu,omitempty" yaml:"gpu"`
+	PythonVersion      string          `json:"python_version,omitempty" yaml:"python_version"`
+	PythonRequirements string          `json:"python_requirements,omitempty" yaml:"python_requirements"`
+	PythonPackages     []string        `json:"python_packages,omitempty" yaml:"python_packages"` // Deprecated, but included for backwards compatibility
+	Run                []RunItem       `json:"run,omitempty" yaml:"run"`
+	SystemPackages     []SystemPackage `json:"system_packages,omitempty" yaml:"system_packages"`
+	PreInstall         []string        `json:"pre_install,omitempty" yaml:"pre_install"` // Deprecated, but included for backwards compatibility
+	CUDA               string          `json:"cuda,omitempty" yaml:"cuda"`
+	CuDNN              string          `json:"cudnn,omitempty" yaml:"cudnn"`
+	CUDAVariant        string          `json:"cuda_variant,omitempty" yaml:"cuda_variant"`
+	Platforms          []string        `json:"platforms,omitempty" yaml:"platforms"`
+	Artifacts          *Artifacts      `json:"artifacts,omitempty" yaml:"artifacts"`
+	PythonInstall      string          `json:"python_install,omitempty" yaml:"python_install"`
+	Installer          string          `json:"installer,omitempty" yaml:"installer"`
+	Cache              *Cache          `json:"cache,omitempty" yaml:"cache"`
+	User               *User           `json:"user,omitempty" yaml:"user"`
+	Sievedata          *ExtraPackage   `json:"sievedata,omitempty" yaml:"sievedata"`
+	Cython             *ExtraPackage   `json:"cython,omitempty" yaml:"cython"`
+	CogRuntime         string          `json:"cog_runtime,omitempty" yaml:"cog_runtime"`
+	Slim               bool            `json:"slim,omitempty" yaml:"slim"`
+	BaseImage          string          `json:"base_image,omitempty" yaml:"base_image"`
 
 	pythonRequirementsContent []string
 }
 
+// Artifacts is where the files Cog downloads while building, like tini, pyenv and Python source, come from.
+// Mirror and Dir have the same layout, so a mirror can be populated by copying a directory.
+type Artifacts struct {
+	// Mirror is a URL to download artifacts from instead of their upstream URLs
+	Mirror string `json:"mirror,omitempty" yaml:"mirror"`
+	// Dir is a directory in the project to copy artifacts from, so builds don't need the network
+	Dir string `json:"dir,omitempty" yaml:"dir"`
+}
+
 type Example struct {
 	Input  map[string]string `json:"input" yaml:"input"`
 	Output string            `json:"output" yaml:"output"`
@@ -68,6 +107,8 @@ type Config struct {
 	Image         string `json:"image,omitempty" yaml:"image"`
 	Predict       string `json:"predict,omitempty" yaml:"predict"`
 	Train         string `json:"train,omitempty" yaml:"train"`
+
+	cudaDecision *CUDADecision
 }
 
 func DefaultConfig() *Config {
@@ -97,12 +138,11 @@ func (r *RunItem) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 
 		aux := struct {
-			Command string `yaml:"command"`
-			Mounts  []struct {
-				Type   string `yaml:"type"`
-				ID     string `yaml:"id"`
-				Target string `yaml:"target"`
-			} `yaml:"mounts,omitempty"`
+			Command string            `yaml:"command"`
+			Mounts  []RunMount        `yaml:"mounts,omitempty"`
+			Env     map[string]string `yaml:"env"`
+			Workdir string            `yaml:"workdir"`
+			Stage   string            `yaml:"stage"`
 		}{}
 
 		if err := yaml.Unmarshal(data, &aux); err != nil {
@@ -128,12 +168,11 @@ func (r *RunItem) UnmarshalJSON(data []byte) error {
 		r.Command = v
 	case map[string]interface{}:
 		aux := struct {
-			Command string `json:"command"`
-			Mounts  []struct {
-				Type   string `json:"type"`
-				ID     string `json:"id"`
-				Target string `json:"target"`
-			} `json:"mounts,omitempty"`
+			Command string            `json:"command"`
+			Mounts  []RunMount        `json:"mounts,omitempty"`
+			Env     map[string]string `json:"env"`
+			Workdir string            `json:"workdir"`
+			Stage   string            `json:"stage"`
 		}{}
 
 		jsonData, err := json.Marshal(v)
@@ -171,19 +210,34 @@ func FromYAML(contents []byte) (*Config, error) {
 }
 
 func (c *Config) CUDABaseImageTag() (string, error) {
-	return CUDABaseImageFor(c.Build.CUDA, c.Build.CuDNN, c.SystemVersion)
+	return CUDABaseImageFor(c.Build.CUDA, c.Build.CuDNN, c.SystemVersion, c.Build.CUDAVariant)
+}
+
+// CUDARuntimeImageTag returns the image the runtime stage of slim images is built on
+func (c *Config) CUDARuntimeImageTag() (string, error) {
+	return CUDARuntimeImageFor(c.Build.CUDA, c.Build.CuDNN, c.SystemVersion)
 }
 
+// TorchVersion returns the version of torch in the requirements. If torch isn't pinned to an exact version,
+// it is the newest version that Cog knows about that satisfies the requirement.
 func (c *Config) TorchVersion() (string, bool) {
-	return c.pythonPackageVersion("torch")
+	return c.resolvedPackageVersion("torch", torchVersions(), pythonsFromTorch)
 }
 
 func (c *Config) TorchvisionVersion() (string, bool) {
-	return c.pythonPackageVersion("torchvision")
+	candidates := torchvisionVersions()
+	if torchVersion, ok := c.TorchVersion(); ok {
+		if compatible := torchvisionsFromTorch(torchVersion); len(compatible) > 0 {
+			candidates = compatible
+		}
+	}
+	return c.resolvedPackageVersion("torchvision", candidates, nil)
 }
 
+// TensorFlowVersion returns the version of tensorflow in the requirements. If tensorflow isn't pinned to an exact version,
+// it is the newest version that Cog knows about that satisfies the requirement.
 func (c *Config) TensorFlowVersion() (string, bool) {
-	return c.pythonPackageVersion("tensorflow")
+	return c.resolvedPackageVersion("tensorflow", tfVersions(), pythonsFromTF)
 }
 
 func (c *Config) cudasFromTorch() (torchVersion string, torchCUDAs []string, err error) {
@@ -208,20 +262,65 @@ func (c *Config) cudaFromTF() (tfVersion string, tfCUDA string, tfCuDNN string,
 	return "", "", "", nil
 }
 
-func (c *Config) pythonPackageVersion(name string) (version string, ok bool) {
-	for _, pkg := range c.Build.pythonRequirementsContent {
-		pkgName, version, _, _, err := splitPinnedPythonRequirement(pkg)
-		if err != nil {
-			// package is not in package==version format
+// pythonRequirement returns the first requirement for a package, or nil if there isn't one
+func (c *Config) pythonRequirement(name string) *Requirement {
+	for _, line := range c.Build.pythonRequirementsContent {
+		req, err := ParseRequirement(line)
+		if err != nil || req == nil {
 			continue
 		}
-		if pkgName == name {
-			return version, true
+		if normalizePackageName(req.Name) == normalizePackageName(name) {
+			return req
+		}
+	}
+	return nil
+}
+
+// pythonPackageVersion returns the version a package is pinned to with package==version
+func (c *Config) pythonPackageVersion(name string) (version string, ok bool) {
+	req := c.pythonRequirement(name)
+	if req == nil || req.Version() == "" {
+		return "", false
+	}
+	return req.Version(), true
+}
+
+// resolvedPackageVersion returns the version a package is pinned to. If the requirement has a version range instead,
+// it returns the newest of the candidate versions that satisfies it, preferring versions that support python_version.
+func (c *Config) resolvedPackageVersion(name string, candidates []string, pythonsFor func(string) []string) (string, bool) {
+	req := c.pythonRequirement(name)
+	if req == nil {
+		return "", false
+	}
+	if version := req.Version(); version != "" {
+		return version, true
+	}
+	if len(req.Specifiers) == 0 {
+		return "", false
+	}
+	if python, ok := c.pythonMinorVersion(); ok && pythonsFor != nil {
+		supported := slices.FilterString(candidates, func(candidate string) bool {
+			return sliceContains(pythonsFor(candidate), python)
+		})
+		if best := req.BestMatch(supported); best != "" {
+			return best, true
 		}
 	}
+	if best := req.BestMatch(candidates); best != "" {
+		return best, true
+	}
 	return "", false
 }
 
+// pythonMinorVersion returns python_version as major.minor
+func (c *Config) pythonMinorVersion() (string, bool) {
+	major, minor, err := splitPythonVersion(c.Build.PythonVersion)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%d.%d", major, minor), true
+}
+
 func splitPythonVersion(version string) (major int, minor int, err error) {
 	version = strings.TrimSpace(version)
 	parts := strings.SplitN(version, ".", 3)
@@ -255,7 +354,6 @@ func ValidateModelPythonVersion(version string) error {
 }
 
 func (c *Config) ValidateAndComplete(projectDir string) error {
-	// TODO(andreas): validate that torch/torchvision/torchaudio are compatible
 	// TODO(andreas): warn if user specifies tensorflow-gpu instead of tensorflow
 	// TODO(andreas): use pypi api to validate that all python versions exist
 
@@ -276,7 +374,72 @@ func (c *Config) ValidateAndComplete(projectDir string) error {
 		errs = append(errs, fmt.Errorf("Only one of python_packages or python_requirements can be set in your cog.yaml, not both"))
 	}
 
-	// Load python_requirements into memory to simplify reading it multiple times
+	for _, platform := range c.Build.Platforms {
+		if _, _, err := ParsePlatform(platform); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Build.Artifacts != nil {
+		if err := c.Build.Artifacts.validate(projectDir); err != nil {
+			errs = append(errs, err)
+		}
+		if c.Build.Artifacts.Dir != "" && c.Build.PythonInstall == PythonInstallUV {
+			errs = append(errs, fmt.Errorf("python_install: uv downloads Python itself, so it can't be used with artifacts.dir. Use python_install: standalone instead"))
+		}
+	}
+
+	if c.Build.Cache != nil {
+		if err := c.Build.Cache.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := c.SievedataRequirement(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.CythonRequirement(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.validateCogRuntime(projectDir); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, run := range c.Build.Run {
+		if err := run.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.validateSystemPackages(projectDir); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Build.BaseImage != "" {
+		if c.Build.GPU {
+			errs = append(errs, fmt.Errorf("base_image in cog.yaml can only be used for CPU models. GPU models are built on CUDA images, which you can pick with cuda, cuda_variant and system_version"))
+		}
+		if c.Build.Slim {
+			errs = append(errs, fmt.Errorf("base_image and slim can't both be set in cog.yaml, because slim images are copied to a python:<version>-slim image"))
+		}
+	}
+
+	switch c.Build.CUDAVariant {
+	case "", CUDAVariantDevel, CUDAVariantRuntime:
+	default:
+		errs = append(errs, fmt.Errorf("cuda_variant in cog.yaml must be %s or %s, not %q", CUDAVariantDevel, CUDAVariantRuntime, c.Build.CUDAVariant))
+	}
+
+	if c.Build.User != nil {
+		c.Build.User.complete()
+		if err := c.Build.User.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Load python_requirements into memory to simplify reading it multiple times. It is loaded again each time
+	// the config is validated, so it starts empty.
+	c.Build.pythonRequirementsContent = nil
 	if c.Build.PythonRequirements != "" {
 		fh, err := os.Open(path.Join(projectDir, c.Build.PythonRequirements))
 		if err != nil {
@@ -284,8 +447,19 @@ func (c *Config) ValidateAndComplete(projectDir string) error {
 		}
 		// Use scanner to handle CRLF endings
 		scanner := bufio.NewScanner(fh)
+		continued := ""
 		for scanner.Scan() {
-			c.Build.pythonRequirementsContent = append(c.Build.pythonRequirementsContent, scanner.Text())
+			line := scanner.Text()
+			// Join lines that end in a backslash, like pip does, so hashes stay with their requirement
+			if trimmed := strings.TrimRight(line, " \t"); strings.HasSuffix(trimmed, "\\") {
+				continued += strings.TrimRight(strings.TrimSuffix(trimmed, "\\"), " \t") + " "
+				continue
+			}
+			c.Build.pythonRequirementsContent = append(c.Build.pythonRequirementsContent, continued+strings.TrimLeft(line, " \t"))
+			continued = ""
+		}
+		if continued != "" {
+			c.Build.pythonRequirementsContent = append(c.Build.pythonRequirementsContent, strings.TrimSpace(continued))
 		}
 	}
 
@@ -294,6 +468,14 @@ func (c *Config) ValidateAndComplete(projectDir string) error {
 		c.Build.pythonRequirementsContent = c.Build.PythonPackages
 	}
 
+	if err := c.validatePythonCompatibility(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validateTorchCompatibility(); err != nil {
+		errs = append(errs, err)
+	}
+
 	if c.Build.GPU {
 		if err := c.validateAndCompleteCUDA(); err != nil {
 			errs = append(errs, err)
@@ -307,40 +489,105 @@ func (c *Config) ValidateAndComplete(projectDir string) error {
 	return nil
 }
 
+// validatePythonCompatibility checks that the pinned versions of torch and tensorflow
+// have builds for python_version
+func (c *Config) validatePythonCompatibility() error {
+	python, ok := c.pythonMinorVersion()
+	if !ok {
+		// Invalid Python versions are reported elsewhere
+		return nil
+	}
+
+	errs := []error{}
+	if torchVersion, ok := c.TorchVersion(); ok {
+		if pythons := pythonsFromTorch(torchVersion); pythons != nil && !sliceContains(pythons, python) {
+			errs = append(errs, pythonIncompatibleError("torch", torchVersion, python, pythons, latestTorchForPython(python)))
+		}
+	}
+	if tfVersion, ok := c.TensorFlowVersion(); ok {
+		if pythons := pythonsFromTF(tfVersion); pythons != nil && !sliceContains(pythons, python) {
+			errs = append(errs, pythonIncompatibleError("tensorflow", tfVersion, python, pythons, latestTFForPython(python)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func pythonIncompatibleError(pkg string, pkgVersion string, python string, pythons []string, suggestion string) error {
+	msg := fmt.Sprintf(`%s==%s doesn't support Python %s.
+Python versions supported by %s==%s are: %s`, pkg, pkgVersion, python, pkg, pkgVersion, strings.Join(pythons, ", "))
+	if len(pythons) == 1 {
+		msg = fmt.Sprintf(`%s==%s doesn't support Python %s.
+Python version supported by %s==%s is: %s`, pkg, pkgVersion, python, pkg, pkgVersion, pythons[0])
+	}
+	if suggestion != "" {
+		verb := "upgrade"
+		if !pep440Greater(suggestion, pkgVersion) {
+			verb = "downgrade"
+		}
+		msg += fmt.Sprintf("\nEither set python_version in cog.yaml to one of these versions, or %s to %s==%s, which supports Python %s.", verb, pkg, suggestion, python)
+	}
+	return errors.New(msg)
+}
+
+// validateTorchCompatibility checks that the pinned versions of torchvision and torchaudio
+// were released alongside the pinned version of torch
+func (c *Config) validateTorchCompatibility() error {
+	torchVersion, ok := c.TorchVersion()
+	if !ok {
+		return nil
+	}
+
+	errs := []error{}
+	for _, lib := range []struct {
+		name       string
+		compatible []string
+	}{
+		{"torchvision", torchvisionsFromTorch(torchVersion)},
+		{"torchaudio", torchaudiosFromTorch(torchVersion)},
+	} {
+		libVersion, ok := c.pythonPackageVersion(lib.name)
+		if !ok || len(lib.compatible) == 0 {
+			continue
+		}
+		// Ignore local version labels like +cu118
+		libVersion = strings.Split(libVersion, "+")[0]
+		if !sliceContains(lib.compatible, libVersion) {
+			compatible := fmt.Sprintf("Compatible %s version is: %s", lib.name, lib.compatible[0])
+			if len(lib.compatible) > 1 {
+				compatible = fmt.Sprintf("Compatible %s versions are: %s", lib.name, strings.Join(lib.compatible, ", "))
+			}
+			errs = append(errs, fmt.Errorf("%s==%s is not compatible with torch==%s.\n%s", lib.name, libVersion, torchVersion, compatible))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // PythonRequirementsForArch returns a requirements.txt file with all the GPU packages resolved for given OS and architecture.
 func (c *Config) PythonRequirementsForArch(goos string, goarch string, excludePackages []string) (string, error) {
 	packages := []string{}
-	findLinksSet := map[string]bool{}
-	extraIndexURLSet := map[string]bool{}
+	findLinksList := []string{}
+	extraIndexURLs := []string{}
 	for _, pkg := range c.Build.pythonRequirementsContent {
 		if slices.ContainsString(excludePackages, pkg) {
 			continue
 		}
 
-		archPkg, findLinksList, extraIndexURLs, err := c.pythonPackageForArch(pkg, goos, goarch)
+		archPkg, pkgFindLinks, pkgExtraIndexURLs, err := c.pythonPackageForArch(pkg, goos, goarch)
 		if err != nil {
 			return "", err
 		}
 		packages = append(packages, archPkg)
-		if len(findLinksList) > 0 {
-			for _, fl := range findLinksList {
-				findLinksSet[fl] = true
-			}
-		}
-		if len(extraIndexURLs) > 0 {
-			for _, u := range extraIndexURLs {
-				extraIndexURLSet[u] = true
-			}
-		}
+		findLinksList = appendMissing(findLinksList, pkgFindLinks...)
+		extraIndexURLs = appendMissing(extraIndexURLs, pkgExtraIndexURLs...)
 	}
 
 	// Create final requirements.txt output
 	// Put index URLs first
 	lines := []string{}
-	for findLinks := range findLinksSet {
+	for _, findLinks := range findLinksList {
 		lines = append(lines, "--find-links "+findLinks)
 	}
-	for extraIndexURL := range extraIndexURLSet {
+	for _, extraIndexURL := range extraIndexURLs {
 		lines = append(lines, "--extra-index-url "+extraIndexURL)
 	}
 
@@ -350,65 +597,53 @@ func (c *Config) PythonRequirementsForArch(goos string, goarch string, excludePa
 	return strings.Join(lines, "\n"), nil
 }
 
-// pythonPackageForArch takes a package==version line and
-// returns a package==version and index URL resolved to the correct GPU package for the given OS and architecture
-func (c *Config) pythonPackageForArch(pkg, goos, goarch string) (actualPackage string, findLinksList []string, extraIndexURLs []string, err error) {
-	name, version, findLinksList, extraIndexURLs, err := splitPinnedPythonRequirement(pkg)
-	if err != nil {
-		// It's not pinned, so just return the line verbatim
-		return pkg, []string{}, []string{}, nil
-	}
-	if len(extraIndexURLs) > 0 {
-		return name + "==" + version, findLinksList, extraIndexURLs, nil
+// pythonPackageForArch takes a requirements.txt line and returns the requirement and index URLs
+// resolved to the correct GPU package for the given OS and architecture
+func (c *Config) pythonPackageForArch(line, goos, goarch string) (actualPackage string, findLinksList []string, extraIndexURLs []string, err error) {
+	req, err := ParseRequirement(line)
+	if err != nil || req == nil || len(req.Hashes) > 0 {
+		// Cog can't parse it, or the hashes pin it to specific files, so just return the line verbatim
+		return line, []string{}, []string{}, nil
 	}
+	hasIndexes := len(req.FindLinks) > 0 || len(req.ExtraIndexURLs) > 0
 
-	extraIndexURL := ""
-	findLinks := ""
-	switch name {
-	case "tensorflow":
-		if c.Build.GPU {
-			name, version, err = tfGPUPackage(version, c.Build.CUDA)
-			if err != nil {
-				return "", nil, nil, err
-			}
-		}
-		// There is no CPU case for tensorflow because the default package is just the CPU package, so no transformation of version is needed
-	case "torch":
-		if c.Build.GPU {
-			name, version, findLinks, extraIndexURL, err = torchGPUPackage(version, c.Build.CUDA)
-			if err != nil {
-				return "", nil, nil, err
-			}
-		} else {
-			name, version, findLinks, extraIndexURL, err = torchCPUPackage(version, goos, goarch)
-			if err != nil {
-				return "", nil, nil, err
-			}
-		}
-	case "torchvision":
-		if c.Build.GPU {
-			name, version, findLinks, extraIndexURL, err = torchvisionGPUPackage(version, c.Build.CUDA)
-			if err != nil {
-				return "", nil, nil, err
-			}
-		} else {
-			name, version, findLinks, extraIndexURL, err = torchvisionCPUPackage(version, goos, goarch)
-			if err != nil {
-				return "", nil, nil, err
-			}
+	resolved := *req
+	if resolver, ok := packageResolverFor(req.Name); ok && len(req.ExtraIndexURLs) == 0 {
+		resolved, err = resolver(c, resolved, goos, goarch)
+		if err != nil {
+			return "", nil, nil, err
 		}
 	}
-	pkgWithVersion := name
-	if version != "" {
-		pkgWithVersion += "==" + version
+	if !hasIndexes && reflect.DeepEqual(resolved, *req) {
+		// Keep the line exactly as the user wrote it
+		return line, []string{}, []string{}, nil
+	}
+	return resolved.String(), resolved.FindLinks, resolved.ExtraIndexURLs, nil
+}
+
+func (a *Artifacts) validate(projectDir string) error {
+	if a.Mirror != "" && a.Dir != "" {
+		return fmt.Errorf("Only one of artifacts.mirror or artifacts.dir can be set in your cog.yaml, not both")
 	}
-	if extraIndexURL != "" {
-		extraIndexURLs = []string{extraIndexURL}
+	if a.Mirror != "" {
+		u, err := url.Parse(a.Mirror)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("artifacts.mirror in cog.yaml must be an http or https URL, not %q", a.Mirror)
+		}
 	}
-	if findLinks != "" {
-		findLinksList = []string{findLinks}
+	if a.Dir != "" {
+		if !filepath.IsLocal(a.Dir) {
+			return fmt.Errorf("artifacts.dir in cog.yaml must be a directory inside your project, not %q", a.Dir)
+		}
+		info, err := os.Stat(path.Join(projectDir, a.Dir))
+		if err != nil {
+			return fmt.Errorf("Failed to read artifacts.dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("artifacts.dir in cog.yaml must be a directory, but %s is a file", a.Dir)
+		}
 	}
-	return pkgWithVersion, findLinksList, extraIndexURLs, nil
+	return nil
 }
 
 func ValidateCudaVersion(cudaVersion string) error {
@@ -429,6 +664,14 @@ func ValidateCudaVersion(cudaVersion string) error {
 }
 
 func (c *Config) validateAndCompleteCUDA() error {
+	// ValidateAndComplete is called more than once, and the versions completed the first time
+	// shouldn't be mistaken for versions set in cog.yaml
+	if d := c.cudaDecision; d != nil && d.CUDA == c.Build.CUDA && d.CuDNN == c.Build.CuDNN {
+		return nil
+	}
+
+	decision := newCUDADecision(c)
+
 	if c.Build.CUDA != "" {
 		if err := ValidateCudaVersion(c.Build.CUDA); err != nil {
 			return err
@@ -451,6 +694,12 @@ Compatible CuDNN versions are: %s`, c.Build.CUDA, c.Build.CuDNN, strings.Join(co
 	if err != nil {
 		return err
 	}
+	if torchVersion != "" {
+		decision.matchTorch(torchVersion)
+	}
+	if tfVersion != "" {
+		decision.matchTF(tfVersion)
+	}
 	// The pre-compiled TensorFlow binaries requires specific CUDA/CuDNN versions to be
 	// installed, but Torch bundles their own CUDA/CuDNN libraries.
 
@@ -461,27 +710,28 @@ Compatible CuDNN versions are: %s`, c.Build.CUDA, c.Build.CuDNN, strings.Join(co
 			if tfCuDNN == "" {
 				return fmt.Errorf("Cog doesn't know what CUDA version is compatible with tensorflow==%s. You might need to upgrade Cog: https://github.com/replicate/cog#upgrade\n\nIf that doesn't work, you need to set the 'cuda' option in cog.yaml to set what version to use. You might be able to find this out from https://www.tensorflow.org/", tfVersion)
 			}
-			console.Debugf("Setting CUDA to version %s from Tensorflow version", tfCUDA)
-			c.Build.CUDA = tfCUDA
-		case tfCUDA == "" || version.EqualMinor(tfCUDA, c.Build.CUDA):
-			console.Warnf("Cog doesn't know if CUDA %s is compatible with Tensorflow %s. This might cause CUDA problems.", c.Build.CUDA, tfVersion)
+			c.Build.CUDA, decision.CUDASource = tfCUDA, CUDASourceTensorFlow
+			console.Debugf("Setting CUDA to version %s from Tensorflow version", c.Build.CUDA)
+		case tfCUDA == "" || !version.EqualMinor(tfCUDA, c.Build.CUDA):
+			decision.warnf("Cog doesn't know if CUDA %s is compatible with Tensorflow %s. This might cause CUDA problems.", c.Build.CUDA, tfVersion)
 			if tfCUDA != "" {
-				console.Warnf("Try %s instead?", tfCUDA)
+				decision.warnf("Try %s instead?", tfCUDA)
 			}
 		}
 
 		switch {
 		case c.Build.CuDNN == "" && tfCuDNN != "":
-			console.Debugf("Setting CuDNN to version %s from Tensorflow version", tfCuDNN)
-			c.Build.CuDNN = tfCuDNN
+			c.Build.CuDNN, decision.CuDNNSource = tfCuDNN, CUDASourceTensorFlow
+			console.Debugf("Setting CuDNN to version %s from Tensorflow version", c.Build.CuDNN)
 		case c.Build.CuDNN == "":
 			c.Build.CuDNN, err = latestCuDNNForCUDA(c.Build.CUDA)
 			if err != nil {
 				return err
 			}
-			console.Debugf("Setting CuDNN to version %s", c.Build.CUDA)
+			decision.CuDNNSource = CUDASourceBaseImages
+			console.Debugf("Setting CuDNN to version %s", c.Build.CuDNN)
 		case tfCuDNN != c.Build.CuDNN:
-			console.Warnf("Cog doesn't know if cuDNN %s is compatible with Tensorflow %s. This might cause CUDA problems.", c.Build.CuDNN, tfVersion)
+			decision.warnf("Cog doesn't know if cuDNN %s is compatible with Tensorflow %s. This might cause CUDA problems.", c.Build.CuDNN, tfVersion)
 			return fmt.Errorf(`The specified cuDNN version %s is not compatible with tensorflow==%s.
 Compatible cuDNN version is: %s`, c.Build.CuDNN, tfVersion, tfCuDNN)
 		}
@@ -491,13 +741,13 @@ Compatible cuDNN version is: %s`, c.Build.CuDNN, tfVersion, tfCuDNN)
 			if len(torchCUDAs) == 0 {
 				return fmt.Errorf("Cog doesn't know what CUDA version is compatible with torch==%s. You might need to upgrade Cog: https://github.com/replicate/cog#upgrade\n\nIf that doesn't work, you need to set the 'cuda' option in cog.yaml to set what version to use. You might be able to find this out from https://pytorch.org/", torchVersion)
 			}
-			c.Build.CUDA = latestCUDAFrom(torchCUDAs)
+			c.Build.CUDA, decision.CUDASource = latestCUDAFrom(torchCUDAs), CUDASourceTorch
 			console.Debugf("Setting CUDA to version %s from Torch version", c.Build.CUDA)
 		case len(slices.FilterString(torchCUDAs, func(torchCUDA string) bool { return version.EqualMinor(torchCUDA, c.Build.CUDA) })) == 0:
 			// TODO: can we suggest a CUDA version known to be compatible?
-			console.Warnf("Cog doesn't know if CUDA %s is compatible with PyTorch %s. This might cause CUDA problems.", c.Build.CUDA, torchVersion)
+			decision.warnf("Cog doesn't know if CUDA %s is compatible with PyTorch %s. This might cause CUDA problems.", c.Build.CUDA, torchVersion)
 			if len(torchCUDAs) > 0 {
-				console.Warnf("Try %s instead?", torchCUDAs[len(torchCUDAs)-1])
+				decision.warnf("Try %s instead?", torchCUDAs[len(torchCUDAs)-1])
 			}
 		}
 
@@ -506,11 +756,12 @@ Compatible cuDNN version is: %s`, c.Build.CuDNN, tfVersion, tfCuDNN)
 			if err != nil {
 				return err
 			}
-			console.Debugf("Setting CuDNN to version %s", c.Build.CUDA)
+			decision.CuDNNSource = CUDASourceBaseImages
+			console.Debugf("Setting CuDNN to version %s", c.Build.CuDNN)
 		}
 	default:
 		if c.Build.CUDA == "" {
-			c.Build.CUDA = defaultCUDA()
+			c.Build.CUDA, decision.CUDASource = defaultCUDA(), CUDASourceDefault
 			console.Debugf("Setting CUDA to version %s", c.Build.CUDA)
 		}
 		if c.Build.CuDNN == "" {
@@ -518,55 +769,39 @@ Compatible cuDNN version is: %s`, c.Build.CuDNN, tfVersion, tfCuDNN)
 			if err != nil {
 				return err
 			}
-			console.Debugf("Setting CuDNN to version %s", c.Build.CUDA)
+			decision.CuDNNSource = CUDASourceBaseImages
+			console.Debugf("Setting CuDNN to version %s", c.Build.CuDNN)
 		}
 	}
 
+	decision.CUDA = c.Build.CUDA
+	decision.CuDNN = c.Build.CuDNN
+	if c.SystemVersion != "" {
+		if err := validateSystemVersion(c.Build.CUDA, c.Build.CuDNN, c.SystemVersion); err != nil {
+			return err
+		}
+	}
+	// A missing base image is an error when the Dockerfile is generated, so it is only recorded here
+	if baseImage, err := c.CUDABaseImageTag(); err == nil {
+		decision.BaseImage = baseImage
+	}
+
+	// The decision is only kept once the versions have been checked, so a config that failed is checked again
+	c.cudaDecision = decision
 	return nil
 }
 
-// splitPythonPackage returns the name, version, findLinks, and extraIndexURLs from a requirements.txt line
+// splitPinnedPythonRequirement returns the name, version, findLinks, and extraIndexURLs from a requirements.txt line
 // in the form name==version [--find-links=<findLink>] [-f <findLink>] [--extra-index-url=<extraIndexURL>]
 func splitPinnedPythonRequirement(requirement string) (name string, version string, findLinks []string, extraIndexURLs []string, err error) {
-	pinnedPackageRe := regexp.MustCompile(`(?:([a-zA-Z0-9\-_]+)==([^ ]+)|--find-links=([^\s]+)|-f\s+([^\s]+)|--extra-index-url=([^\s]+))`)
-
-	matches := pinnedPackageRe.FindAllStringSubmatch(requirement, -1)
-	if matches == nil {
-		return "", "", nil, nil, fmt.Errorf("Package %s is not in the expected format", requirement)
-	}
-
-	nameFound := false
-	versionFound := false
-
-	for _, match := range matches {
-		if match[1] != "" {
-			name = match[1]
-			nameFound = true
-		}
-
-		if match[2] != "" {
-			version = match[2]
-			versionFound = true
-		}
-
-		if match[3] != "" {
-			findLinks = append(findLinks, match[3])
-		}
-
-		if match[4] != "" {
-			findLinks = append(findLinks, match[4])
-		}
-
-		if match[5] != "" {
-			extraIndexURLs = append(extraIndexURLs, match[5])
-		}
+	req, err := ParseRequirement(requirement)
+	if err != nil {
+		return "", "", nil, nil, err
 	}
-
-	if !nameFound || !versionFound {
+	if req == nil || req.Version() == "" {
 		return "", "", nil, nil, fmt.Errorf("Package name or version is missing in %s", requirement)
 	}
-
-	return name, version, findLinks, extraIndexURLs, nil
+	return req.Name, req.Version(), req.FindLinks, req.ExtraIndexURLs, nil
 }
 
 func sliceContains(slice []string, s string) bool {
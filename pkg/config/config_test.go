@@ -247,7 +247,7 @@ func TestValidateAndCompleteCUDAForAllTF(t *testing.T) {
 		config := &Config{
 			Build: &Build{
 				GPU:           true,
-				PythonVersion: "3.8",
+				PythonVersion: compat.Pythons[len(compat.Pythons)-1],
 				PythonPackages: []string{
 					"tensorflow==" + compat.TF,
 				},
@@ -534,6 +534,69 @@ func TestCUDABaseImageTagTorch(t *testing.T) {
 	require.Equal(t, "nvidia/cuda:12.1.1-cudnn8-devel-ubuntu22.04", imageTag)
 }
 
+func TestCUDABaseImageTagVariantAndSystemVersion(t *testing.T) {
+	config := &Config{
+		SystemVersion: "20.04",
+		Build: &Build{
+			GPU:           true,
+			CUDA:          "11.8",
+			CUDAVariant:   CUDAVariantRuntime,
+			PythonVersion: "3.10",
+		},
+	}
+
+	err := config.ValidateAndComplete("")
+	require.NoError(t, err)
+
+	imageTag, err := config.CUDABaseImageTag()
+	require.NoError(t, err)
+	require.Equal(t, "nvidia/cuda:11.8.0-cudnn8-runtime-ubuntu20.04", imageTag)
+}
+
+func TestUnavailableSystemVersion(t *testing.T) {
+	config := &Config{
+		SystemVersion: "16.04",
+		Build: &Build{
+			GPU:           true,
+			CUDA:          "11.8",
+			PythonVersion: "3.10",
+		},
+	}
+
+	err := config.ValidateAndComplete("")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "system_version 16.04 in cog.yaml isn't available for CUDA 11.8 and cuDNN 8")
+	require.Contains(t, err.Error(), "Available Ubuntu releases are: 18.04, 20.04, 22.04")
+}
+
+func TestInvalidCUDAVariant(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			CUDAVariant:   "base",
+			PythonVersion: "3.10",
+		},
+	}
+
+	err := config.ValidateAndComplete("")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cuda_variant")
+}
+
+func TestBaseImageOnlyForCPU(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			BaseImage:     "python:3.11-slim",
+		},
+	}
+
+	err := config.ValidateAndComplete("")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "base_image in cog.yaml can only be used for CPU models")
+}
+
 func TestBuildRunItemStringYAML(t *testing.T) {
 	type BuildWrapper struct {
 		Build *Build `yaml:"build"`
@@ -714,3 +777,243 @@ func TestSplitPinnedPythonRequirement(t *testing.T) {
 		}
 	}
 }
+
+func TestPythonVersionIncompatibleWithTorch(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.12",
+			PythonPackages: []string{
+				"torch==2.1.0",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.Error(t, err)
+	require.Equal(t, `torch==2.1.0 doesn't support Python 3.12.
+Python versions supported by torch==2.1.0 are: 3.7, 3.8, 3.9, 3.10, 3.11
+Either set python_version in cog.yaml to one of these versions, or upgrade to torch==2.3.1, which supports Python 3.12.`, err.Error())
+}
+
+func TestPythonVersionIncompatibleWithTensorflow(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.8.10",
+			PythonPackages: []string{
+				"tensorflow==2.16.1",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "tensorflow==2.16.1 doesn't support Python 3.8.")
+	require.Contains(t, err.Error(), "downgrade to tensorflow==2.13.0, which supports Python 3.8")
+}
+
+func TestPythonVersionCompatibleWithTorchMinorVersion(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			PythonPackages: []string{
+				"torch==2.0",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.NoError(t, err)
+}
+
+func TestTorchvisionIncompatibleWithTorch(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.10",
+			PythonPackages: []string{
+				"torch==2.1.0",
+				"torchvision==0.15.2",
+				"torchaudio==2.0.2+cu118",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.Error(t, err)
+	require.Equal(t, `torchvision==0.15.2 is not compatible with torch==2.1.0.
+Compatible torchvision version is: 0.16.0
+torchaudio==2.0.2 is not compatible with torch==2.1.0.
+Compatible torchaudio version is: 2.1.0`, err.Error())
+}
+
+func TestTorchvisionIncompatibleWithTorchSeveralVersions(t *testing.T) {
+	matrix := TorchCompatibilityMatrix
+	t.Cleanup(func() { TorchCompatibilityMatrix = matrix })
+	TorchCompatibilityMatrix = []TorchCompatibility{
+		{Torch: "2.99.0", Torchvision: "0.99.0", Pythons: []string{"3.10"}},
+		{Torch: "2.99.0", Torchvision: "0.99.1", Pythons: []string{"3.10"}},
+	}
+
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.10",
+			PythonPackages: []string{
+				"torch==2.99.0",
+				"torchvision==0.15.2",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.Error(t, err)
+	require.Equal(t, `torchvision==0.15.2 is not compatible with torch==2.99.0.
+Compatible torchvision versions are: 0.99.0, 0.99.1`, err.Error())
+}
+
+func TestPythonIncompatibleErrorSingleVersion(t *testing.T) {
+	err := pythonIncompatibleError("torch", "2.99.0", "3.8", []string{"3.12"}, "")
+	require.Equal(t, `torch==2.99.0 doesn't support Python 3.8.
+Python version supported by torch==2.99.0 is: 3.12`, err.Error())
+
+	err = pythonIncompatibleError("torch", "2.99.0", "3.8", []string{"3.11", "3.12"}, "")
+	require.Equal(t, `torch==2.99.0 doesn't support Python 3.8.
+Python versions supported by torch==2.99.0 are: 3.11, 3.12`, err.Error())
+}
+
+func TestCUDAFromTorchVersionRange(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			PythonPackages: []string{
+				"torch>=2.1,<2.3",
+				"torchvision",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.NoError(t, err)
+	require.Equal(t, "12.1", config.Build.CUDA)
+
+	torchVersion, ok := config.TorchVersion()
+	require.True(t, ok)
+	require.Equal(t, "2.2.2", torchVersion)
+
+	requirements, err := config.PythonRequirementsForArch("", "", []string{})
+	require.NoError(t, err)
+	expected := `--extra-index-url https://download.pytorch.org/whl/cu121
+torch==2.2.2
+torchvision`
+	require.Equal(t, expected, requirements)
+}
+
+func TestTorchVersionRangePrefersSupportedPython(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.12",
+			PythonPackages: []string{
+				"torch~=2.1",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.NoError(t, err)
+
+	torchVersion, ok := config.TorchVersion()
+	require.True(t, ok)
+	require.Equal(t, "2.3.1", torchVersion)
+}
+
+func TestCUDAFromTensorflowVersionRange(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			PythonPackages: []string{
+				"tensorflow>=2.12,<2.14",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.NoError(t, err)
+	require.Equal(t, "11.8", config.Build.CUDA)
+
+	tfVersion, ok := config.TensorFlowVersion()
+	require.True(t, ok)
+	require.Equal(t, "2.13.0", tfVersion)
+}
+
+func TestPythonRequirementsWithMarkersExtrasAndHashes(t *testing.T) {
+	tmpDir := t.TempDir()
+	err := os.WriteFile(path.Join(tmpDir, "requirements.txt"), []byte(`torch==2.1.0; sys_platform == "linux"
+zope.interface==6.1
+six==1.16.0 \
+    --hash=sha256:8abb2f1d86890a2dfb989f9a77cfcfd3e47c2a354b01111771326f8aa26e0254
+`), 0o644)
+	require.NoError(t, err)
+
+	config := &Config{
+		Build: &Build{
+			GPU:                true,
+			CUDA:               "11.8",
+			PythonVersion:      "3.11",
+			PythonRequirements: "requirements.txt",
+		},
+	}
+	err = config.ValidateAndComplete(tmpDir)
+	require.NoError(t, err)
+
+	requirements, err := config.PythonRequirementsForArch("", "", []string{})
+	require.NoError(t, err)
+	expected := `--extra-index-url https://download.pytorch.org/whl/cu118
+torch==2.1.0; sys_platform == "linux"
+zope.interface==6.1
+six==1.16.0 --hash=sha256:8abb2f1d86890a2dfb989f9a77cfcfd3e47c2a354b01111771326f8aa26e0254`
+	require.Equal(t, expected, requirements)
+}
+
+func TestValidateArtifacts(t *testing.T) {
+	projectDir := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(projectDir, "artifacts"), 0o755))
+
+	for _, tc := range []struct {
+		artifacts Artifacts
+		err       string
+	}{
+		{Artifacts{Dir: "artifacts"}, ""},
+		{Artifacts{Mirror: "https://mirror.example.com/cog"}, ""},
+		{Artifacts{Dir: "artifacts", Mirror: "https://mirror.example.com/cog"}, "Only one of artifacts.mirror or artifacts.dir"},
+		{Artifacts{Mirror: "mirror.example.com"}, `artifacts.mirror in cog.yaml must be an http or https URL, not "mirror.example.com"`},
+		{Artifacts{Dir: "../artifacts"}, `artifacts.dir in cog.yaml must be a directory inside your project, not "../artifacts"`},
+		{Artifacts{Dir: "missing"}, "Failed to read artifacts.dir"},
+	} {
+		artifacts := tc.artifacts
+		config := &Config{
+			Build: &Build{
+				PythonVersion: "3.10",
+				Artifacts:     &artifacts,
+			},
+		}
+		err := config.ValidateAndComplete(projectDir)
+		if tc.err == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, tc.err)
+		}
+	}
+}
+
+func TestValidatePythonInstallUVWithArtifactsDir(t *testing.T) {
+	projectDir := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(projectDir, "artifacts"), 0o755))
+
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.10",
+			PythonInstall: PythonInstallUV,
+			Artifacts:     &Artifacts{Dir: "artifacts"},
+		},
+	}
+	err := config.ValidateAndComplete(projectDir)
+	require.ErrorContains(t, err, "python_install: uv downloads Python itself, so it can't be used with artifacts.dir")
+
+	config.Build.PythonInstall = "conda"
+	err = config.ValidateAndComplete(projectDir)
+	require.ErrorContains(t, err, "python_install")
+}
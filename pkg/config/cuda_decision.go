@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/console"
+)
+
+// Where a CUDA or cuDNN version in a CUDADecision came from
+const (
+	CUDASourceConfig     = "cog.yaml"
+	CUDASourceTensorFlow = "tensorflow"
+	CUDASourceTorch      = "torch"
+	CUDASourceDefault    = "default"
+	CUDASourceBaseImages = "base images"
+)
+
+// CUDADecision records how Cog chose the CUDA version, cuDNN version and base image for a GPU model,
+// so it can be explained to the user and audited later from the image label
+type CUDADecision struct {
+	// Inputs
+	PythonVersion    string `json:"python_version"`
+	RequestedCUDA    string `json:"requested_cuda,omitempty"`
+	RequestedCuDNN   string `json:"requested_cudnn,omitempty"`
+	SystemVersion    string `json:"system_version,omitempty"`
+	TorchRequirement string `json:"torch_requirement,omitempty"`
+	TFRequirement    string `json:"tensorflow_requirement,omitempty"`
+
+	// Matched compatibility matrix rows
+	Torch     string               `json:"torch,omitempty"`
+	TorchRows []TorchCompatibility `json:"torch_rows,omitempty"`
+	TF        string               `json:"tensorflow,omitempty"`
+	TFRow     *TFCompatibility     `json:"tensorflow_row,omitempty"`
+
+	// Result
+	CUDA        string   `json:"cuda"`
+	CUDASource  string   `json:"cuda_source"`
+	CuDNN       string   `json:"cudnn"`
+	CuDNNSource string   `json:"cudnn_source"`
+	BaseImage   string   `json:"base_image,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// CUDADecision returns how the CUDA version, cuDNN version and base image were chosen. It is nil
+// until ValidateAndComplete has succeeded, and for models that don't use a GPU.
+func (c *Config) CUDADecision() *CUDADecision {
+	return c.cudaDecision
+}
+
+func newCUDADecision(c *Config) *CUDADecision {
+	d := &CUDADecision{
+		PythonVersion:  c.Build.PythonVersion,
+		RequestedCUDA:  c.Build.CUDA,
+		RequestedCuDNN: c.Build.CuDNN,
+		SystemVersion:  c.SystemVersion,
+	}
+	if d.RequestedCUDA != "" {
+		d.CUDASource = CUDASourceConfig
+	}
+	if d.RequestedCuDNN != "" {
+		d.CuDNNSource = CUDASourceConfig
+	}
+	if req := c.pythonRequirement("torch"); req != nil {
+		d.TorchRequirement = req.String()
+	}
+	if req := c.pythonRequirement("tensorflow"); req != nil {
+		d.TFRequirement = req.String()
+	}
+	return d
+}
+
+func (d *CUDADecision) matchTorch(torchVersion string) {
+	d.Torch = torchVersion
+	for _, matrix := range [][]TorchCompatibility{TorchCompatibilityMatrix, TorchMinorCompatibilityMatrix} {
+		for _, compat := range matrix {
+			if compat.TorchVersion() == torchVersion && compat.CUDA != nil {
+				d.TorchRows = append(d.TorchRows, compat)
+			}
+		}
+	}
+}
+
+func (d *CUDADecision) matchTF(tfVersion string) {
+	d.TF = tfVersion
+	for _, compat := range TFCompatibilityMatrix {
+		if compat.TF == tfVersion {
+			compat := compat
+			d.TFRow = &compat
+			return
+		}
+	}
+}
+
+// warnf warns the user and records the warning in the decision
+func (d *CUDADecision) warnf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	d.Warnings = append(d.Warnings, msg)
+	console.Warn(msg)
+}
+
+// String explains the decision in a form that can be shown to the user
+func (d *CUDADecision) String() string {
+	lines := []string{"Inputs:", "  Python: " + d.PythonVersion}
+	if d.RequestedCUDA != "" {
+		lines = append(lines, "  CUDA in cog.yaml: "+d.RequestedCUDA)
+	}
+	if d.RequestedCuDNN != "" {
+		lines = append(lines, "  cuDNN in cog.yaml: "+d.RequestedCuDNN)
+	}
+	if d.SystemVersion != "" {
+		lines = append(lines, "  System version: "+d.SystemVersion)
+	}
+	if d.TorchRequirement != "" {
+		lines = append(lines, "  Requirement: "+d.TorchRequirement)
+	}
+	if d.TFRequirement != "" {
+		lines = append(lines, "  Requirement: "+d.TFRequirement)
+	}
+
+	if d.Torch != "" || d.TF != "" {
+		lines = append(lines, "Compatibility matrix:")
+	}
+	if d.Torch != "" {
+		if len(d.TorchRows) == 0 {
+			lines = append(lines, fmt.Sprintf("  torch %s: no known CUDA builds", d.Torch))
+		}
+		for _, row := range d.TorchRows {
+			lines = append(lines, fmt.Sprintf("  torch %s: CUDA %s, Python %s", row.Torch, *row.CUDA, strings.Join(row.Pythons, ", ")))
+		}
+	}
+	if d.TF != "" {
+		if d.TFRow == nil {
+			lines = append(lines, fmt.Sprintf("  tensorflow %s: unknown", d.TF))
+		} else {
+			lines = append(lines, fmt.Sprintf("  tensorflow %s: CUDA %s, cuDNN %s, Python %s", d.TFRow.TF, d.TFRow.CUDA, d.TFRow.CuDNN, strings.Join(d.TFRow.Pythons, ", ")))
+		}
+	}
+
+	lines = append(lines,
+		"Chosen:",
+		fmt.Sprintf("  CUDA: %s (from %s)", d.CUDA, d.CUDASource),
+		fmt.Sprintf("  cuDNN: %s (from %s)", d.CuDNN, d.CuDNNSource),
+	)
+	if d.BaseImage != "" {
+		lines = append(lines, "  Base image: "+d.BaseImage)
+	} else {
+		lines = append(lines, "  Base image: none matches")
+	}
+
+	if len(d.Warnings) > 0 {
+		lines = append(lines, "Warnings:")
+		for _, warning := range d.Warnings {
+			lines = append(lines, "  "+strings.ReplaceAll(warning, "\n", "\n  "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
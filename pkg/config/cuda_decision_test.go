@@ -0,0 +1,164 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCUDADecisionFromTensorflow(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.8",
+			PythonPackages: []string{
+				"tensorflow==2.12.0",
+			},
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+
+	decision := config.CUDADecision()
+	require.NotNil(t, decision)
+	require.Equal(t, "tensorflow==2.12.0", decision.TFRequirement)
+	require.Equal(t, "2.12.0", decision.TF)
+	require.NotNil(t, decision.TFRow)
+	require.Equal(t, "2.12.0", decision.TFRow.TF)
+	require.Equal(t, "11.8", decision.CUDA)
+	require.Equal(t, CUDASourceTensorFlow, decision.CUDASource)
+	require.Equal(t, "8", decision.CuDNN)
+	require.Equal(t, CUDASourceTensorFlow, decision.CuDNNSource)
+	require.Equal(t, "nvidia/cuda:11.8.0-cudnn8-devel-ubuntu22.04", decision.BaseImage)
+	require.Empty(t, decision.Warnings)
+
+	// Validating again doesn't mistake the completed versions for ones set in cog.yaml
+	require.NoError(t, config.ValidateAndComplete(""))
+	require.Equal(t, CUDASourceTensorFlow, config.CUDADecision().CUDASource)
+	require.Empty(t, config.CUDADecision().RequestedCUDA)
+}
+
+func TestCUDADecisionFromTorch(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.10",
+			PythonPackages: []string{
+				"torch==2.0.1",
+			},
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+
+	decision := config.CUDADecision()
+	require.Equal(t, "2.0.1", decision.Torch)
+	require.NotEmpty(t, decision.TorchRows)
+	for _, row := range decision.TorchRows {
+		require.Equal(t, "2.0.1", row.TorchVersion())
+		require.NotNil(t, row.CUDA)
+	}
+	require.Equal(t, "11.8", decision.CUDA)
+	require.Equal(t, CUDASourceTorch, decision.CUDASource)
+	require.Equal(t, "8", decision.CuDNN)
+	require.Equal(t, CUDASourceBaseImages, decision.CuDNNSource)
+	require.Equal(t, config.Build.CUDA, decision.CUDA)
+	require.Equal(t, config.Build.CuDNN, decision.CuDNN)
+}
+
+func TestCUDADecisionWarnings(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.8",
+			CUDA:          "11.8",
+			PythonPackages: []string{
+				"torch==1.7.1",
+			},
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+
+	decision := config.CUDADecision()
+	require.Equal(t, "11.8", decision.RequestedCUDA)
+	require.Equal(t, CUDASourceConfig, decision.CUDASource)
+	require.Equal(t, []string{
+		"Cog doesn't know if CUDA 11.8 is compatible with PyTorch 1.7.1. This might cause CUDA problems.",
+		"Try 11.0 instead?",
+	}, decision.Warnings)
+	require.Contains(t, decision.String(), "Warnings:\n  Cog doesn't know if CUDA 11.8")
+}
+
+func TestCUDADecisionWarningsTensorflow(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.10",
+			CUDA:          "12.1",
+			PythonPackages: []string{
+				"tensorflow==2.12.0",
+			},
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+	require.Equal(t, []string{
+		"Cog doesn't know if CUDA 12.1 is compatible with Tensorflow 2.12.0. This might cause CUDA problems.",
+		"Try 11.8 instead?",
+	}, config.CUDADecision().Warnings)
+
+	// The CUDA version tensorflow was built for is compatible
+	config = &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.10",
+			CUDA:          "11.8",
+			PythonPackages: []string{
+				"tensorflow==2.12.0",
+			},
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+	require.Empty(t, config.CUDADecision().Warnings)
+}
+
+func TestCUDADecisionDefault(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.10",
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+
+	decision := config.CUDADecision()
+	require.Equal(t, CUDASourceDefault, decision.CUDASource)
+	require.Contains(t, decision.String(), "CUDA: 11.8 (from default)")
+}
+
+func TestCUDADecisionCPU(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.10",
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+	require.Nil(t, config.CUDADecision())
+}
+
+func TestCUDADecisionNotRecordedOnError(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.8",
+			PythonPackages: []string{
+				"torch==0.4.1",
+			},
+		},
+	}
+	require.Error(t, config.ValidateAndComplete(""))
+	require.Nil(t, config.CUDADecision())
+
+	// Validating again must check the versions again rather than reuse a half-made decision
+	err := config.ValidateAndComplete("")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Cog doesn't know what CUDA version is compatible with torch==0.4.1.")
+	require.Nil(t, config.CUDADecision())
+}
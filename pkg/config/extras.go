@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Packages Cog installs in images besides the model's requirements
+const (
+	ExtraSievedata = "sievedata"
+	ExtraCython    = "cython"
+	// DefaultCythonVersion is the version of Cython installed when build.cython isn't set
+	DefaultCythonVersion = "0.29.34"
+)
+
+// ExtraPackage is a package Cog installs in images besides the model's requirements, set with build.sievedata
+// and build.cython. It can be false to not install the package, a version to pin it to, or specifiers or a
+// requirement to install instead, like ">=0.3" or "sievedata @ https://example.com/sievedata.whl".
+type ExtraPackage struct {
+	Disabled    bool
+	Requirement string
+}
+
+func (p *ExtraPackage) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var install bool
+	if err := unmarshal(&install); err == nil {
+		*p = ExtraPackage{Disabled: !install}
+		return nil
+	}
+	var requirement string
+	if err := unmarshal(&requirement); err != nil {
+		return fmt.Errorf("Must be false, a version or a requirement: %w", err)
+	}
+	*p = ExtraPackage{Requirement: requirement}
+	return nil
+}
+
+func (p ExtraPackage) MarshalYAML() (interface{}, error) {
+	if p.Disabled {
+		return false, nil
+	}
+	if p.Requirement == "" {
+		return true, nil
+	}
+	return p.Requirement, nil
+}
+
+func (p *ExtraPackage) UnmarshalJSON(data []byte) error {
+	return p.UnmarshalYAML(func(v interface{}) error {
+		return json.Unmarshal(data, v)
+	})
+}
+
+func (p ExtraPackage) MarshalJSON() ([]byte, error) {
+	v, _ := p.MarshalYAML()
+	return json.Marshal(v)
+}
+
+// SievedataRequirement returns the requirement sievedata is installed with, or nil if it isn't installed.
+// Unless it is pinned in cog.yaml, it has no version, and is pinned when the Dockerfile is generated.
+func (c *Config) SievedataRequirement() (*Requirement, error) {
+	return c.Build.Sievedata.requirement(ExtraSievedata, "")
+}
+
+// CythonRequirement returns the requirement Cython is installed with, or nil if it isn't installed
+func (c *Config) CythonRequirement() (*Requirement, error) {
+	return c.Build.Cython.requirement(ExtraCython, DefaultCythonVersion)
+}
+
+func (p *ExtraPackage) requirement(name string, defaultVersion string) (*Requirement, error) {
+	if p != nil && p.Disabled {
+		return nil, nil
+	}
+	value := ""
+	if p != nil {
+		value = strings.TrimSpace(p.Requirement)
+	}
+
+	line := name
+	switch {
+	case value == "" && defaultVersion != "":
+		line = name + "==" + defaultVersion
+	case value == "":
+	case isPEP440Version(value):
+		line = name + "==" + value
+	case strings.ContainsAny(value[:1], "=<>!~"):
+		line = name + value
+	default:
+		line = value
+	}
+
+	req, err := ParseRequirement(line)
+	if err != nil {
+		return nil, fmt.Errorf("%s in cog.yaml must be false, a version or a requirement: %w", name, err)
+	}
+	if req == nil || normalizePackageName(req.Name) != name {
+		return nil, fmt.Errorf("%s in cog.yaml must be false, a version or a requirement for %s, not %q", name, name, value)
+	}
+	return req, nil
+}
+
+func isPEP440Version(s string) bool {
+	_, err := parsePEP440Version(s)
+	return err == nil
+}
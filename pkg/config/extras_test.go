@@ -0,0 +1,63 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtraRequirements(t *testing.T) {
+	for _, tt := range []struct {
+		sievedata string
+		expected  string
+	}{
+		{"", "sievedata"},
+		{"0.3.12", "sievedata==0.3.12"},
+		{">=0.3,<0.4", "sievedata>=0.3,<0.4"},
+		{"sievedata[all]==0.3.12", "sievedata[all]==0.3.12"},
+		{"sievedata @ https://example.com/sievedata-0.3.12-py3-none-any.whl", "sievedata @ https://example.com/sievedata-0.3.12-py3-none-any.whl"},
+	} {
+		config := &Config{Build: &Build{Sievedata: &ExtraPackage{Requirement: tt.sievedata}}}
+		req, err := config.SievedataRequirement()
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, req.String())
+	}
+
+	config := &Config{Build: &Build{}}
+	req, err := config.CythonRequirement()
+	require.NoError(t, err)
+	require.Equal(t, "cython==0.29.34", req.String())
+
+	config.Build.Cython = &ExtraPackage{Disabled: true}
+	req, err = config.CythonRequirement()
+	require.NoError(t, err)
+	require.Nil(t, req)
+
+	config.Build.Cython = &ExtraPackage{Requirement: "numpy==1.26.4"}
+	_, err = config.CythonRequirement()
+	require.ErrorContains(t, err, `cython in cog.yaml must be false, a version or a requirement for cython, not "numpy==1.26.4"`)
+
+	config.Build.Cython = &ExtraPackage{Requirement: "./Cython.whl"}
+	_, err = config.CythonRequirement()
+	require.ErrorContains(t, err, "cython in cog.yaml must be false, a version or a requirement for cython")
+}
+
+func TestExtraPackageFromYAML(t *testing.T) {
+	config, err := FromYAML([]byte(`
+build:
+  python_version: "3.11"
+  sievedata: false
+  cython: "3.0.10"
+`))
+	require.NoError(t, err)
+	require.Equal(t, &ExtraPackage{Disabled: true}, config.Build.Sievedata)
+	require.Equal(t, &ExtraPackage{Requirement: "3.0.10"}, config.Build.Cython)
+
+	data, err := config.Build.Sievedata.MarshalJSON()
+	require.NoError(t, err)
+	require.Equal(t, "false", string(data))
+
+	pkg := &ExtraPackage{}
+	require.NoError(t, pkg.UnmarshalJSON([]byte(`"3.0.10"`)))
+	require.Equal(t, &ExtraPackage{Requirement: "3.0.10"}, pkg)
+}
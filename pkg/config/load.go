@@ -8,6 +8,7 @@ import (
 
 	"github.com/replicate/cog/pkg/errors"
 	"github.com/replicate/cog/pkg/global"
+	"github.com/replicate/cog/pkg/util/console"
 	"github.com/replicate/cog/pkg/util/files"
 )
 
@@ -42,6 +43,10 @@ func GetConfig(customDir string) (*Config, string, error) {
 		return nil, "", err
 	}
 
+	for _, deprecation := range config.Deprecations() {
+		console.Warn(deprecation)
+	}
+
 	err = config.ValidateAndComplete(rootDir)
 
 	return config, rootDir, err
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Lockfiles that pin Python requirements
+const (
+	// RequirementsLockFilename is written by `cog lock` and installed instead of the requirements if it exists
+	RequirementsLockFilename = "requirements.lock"
+	// UVLockFilename is a uv project lockfile, installed with build.installer: uv if there's no requirements.lock
+	UVLockFilename = "uv.lock"
+)
+
+// Installers of Python packages, set with build.installer
+const (
+	InstallerPip = "pip"
+	InstallerUV  = "uv"
+)
+
+// UsesUV returns whether Python packages are installed with uv
+func (c *Config) UsesUV() bool {
+	return c.Build.Installer == InstallerUV
+}
+
+// RequirementsLockFilenameForArch returns the name of the lockfile for an architecture. Images for one
+// platform use requirements.lock, and images for more than one have a lockfile for each architecture.
+func (c *Config) RequirementsLockFilenameForArch(goarch string) string {
+	if len(c.Platforms()) == 1 {
+		return RequirementsLockFilename
+	}
+	return fmt.Sprintf("requirements-%s.lock", goarch)
+}
+
+// requirementsLockHeaderPrefix starts every lockfile written by `cog lock`
+const requirementsLockHeaderPrefix = "# This file was generated by cog lock"
+
+// IsCogRequirementsLock returns whether a lockfile was written by `cog lock`, rather than by another tool
+func IsCogRequirementsLock(contents string) bool {
+	return strings.HasPrefix(contents, requirementsLockHeaderPrefix)
+}
+
+// RequirementsLockHeader returns the header that `cog lock` writes at the top of a lockfile. It has a hash
+// of the requirements the lockfile was made from, so out of date lockfiles can be found.
+func (c *Config) RequirementsLockHeader(goos string, goarch string) (string, error) {
+	requirements, err := c.PythonRequirementsForArch(goos, goarch, []string{})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s/%s\n%s", c.Build.PythonVersion, goos, goarch, requirements)))
+	return fmt.Sprintf(`%s for Python %s on %s/%s. Run cog lock again when you change your requirements.
+# cog-requirements-sha256: %s
+`, requirementsLockHeaderPrefix, c.Build.PythonVersion, goos, goarch, hex.EncodeToString(hash[:])), nil
+}
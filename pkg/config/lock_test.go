@@ -0,0 +1,50 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequirementsLockFilenameForArch(t *testing.T) {
+	config := &Config{Build: &Build{}}
+	require.Equal(t, "requirements.lock", config.RequirementsLockFilenameForArch("amd64"))
+
+	config.Build.Platforms = []string{"linux/amd64", "linux/arm64"}
+	require.Equal(t, "requirements-amd64.lock", config.RequirementsLockFilenameForArch("amd64"))
+	require.Equal(t, "requirements-arm64.lock", config.RequirementsLockFilenameForArch("arm64"))
+}
+
+func TestRequirementsLockHeader(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.11",
+			PythonPackages: []string{
+				"ffmpeg-python==0.2.0",
+			},
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+
+	header, err := config.RequirementsLockHeader("linux", "amd64")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(header, "# This file was generated by cog lock for Python 3.11 on linux/amd64."))
+	require.Contains(t, header, "# cog-requirements-sha256: ")
+	require.True(t, IsCogRequirementsLock(header+"ffmpeg-python==0.2.0\n"))
+	require.False(t, IsCogRequirementsLock("# This file was autogenerated by uv via the following command:\nffmpeg-python==0.2.0\n"))
+
+	sameHeader, err := config.RequirementsLockHeader("linux", "amd64")
+	require.NoError(t, err)
+	require.Equal(t, header, sameHeader)
+
+	otherArchHeader, err := config.RequirementsLockHeader("linux", "arm64")
+	require.NoError(t, err)
+	require.NotEqual(t, header, otherArchHeader)
+
+	config.Build.PythonPackages = []string{"ffmpeg-python==0.2.1"}
+	require.NoError(t, config.ValidateAndComplete(""))
+	changedHeader, err := config.RequirementsLockHeader("linux", "amd64")
+	require.NoError(t, err)
+	require.NotEqual(t, header, changedHeader)
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Deprecations returns a message for each deprecated option the config uses, saying what to use instead
+func (c *Config) Deprecations() []string {
+	deprecations := []string{}
+	if c.Build != nil && len(c.Build.PreInstall) > 0 {
+		deprecations = append(deprecations, "pre_install in cog.yaml is deprecated. Use run with stage: before_requirements instead, or run 'cog config migrate' to update cog.yaml")
+	}
+	return deprecations
+}
+
+// Migrate rewrites deprecated options in the contents of cog.yaml to the options that replaced them, keeping
+// comments and the order of keys. It returns the new contents and a description of each change, which is empty
+// if there was nothing to migrate.
+func Migrate(contents []byte) ([]byte, []string, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(contents, &doc); err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse cog.yaml: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return contents, []string{}, nil
+	}
+	build := mappingValue(doc.Content[0], "build")
+	if build == nil || build.Kind != yamlv3.MappingNode {
+		return contents, []string{}, nil
+	}
+
+	changes := []string{}
+	if change, err := migratePreInstall(build); err != nil {
+		return nil, nil, err
+	} else if change != "" {
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return contents, changes, nil
+	}
+
+	var out bytes.Buffer
+	encoder := yamlv3.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("Failed to write cog.yaml: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, fmt.Errorf("Failed to write cog.yaml: %w", err)
+	}
+
+	// Check the migrated config still parses and matches the schema
+	if _, err := FromYAML(out.Bytes()); err != nil {
+		return nil, nil, fmt.Errorf("Failed to migrate cog.yaml: %w", err)
+	}
+	return out.Bytes(), changes, nil
+}
+
+// migratePreInstall moves the commands in build.pre_install to the start of build.run, to run before Python
+// packages are installed like they did
+func migratePreInstall(build *yamlv3.Node) (string, error) {
+	index := mappingIndex(build, "pre_install")
+	if index < 0 {
+		return "", nil
+	}
+	key, preInstall := build.Content[index], build.Content[index+1]
+	build.Content = append(build.Content[:index], build.Content[index+2:]...)
+	if preInstall.Kind != yamlv3.SequenceNode || len(preInstall.Content) == 0 {
+		return "Removed pre_install, which was empty", nil
+	}
+
+	items := []*yamlv3.Node{}
+	for _, command := range preInstall.Content {
+		if command.Kind != yamlv3.ScalarNode {
+			return "", fmt.Errorf("Failed to migrate pre_install in cog.yaml: line %d isn't a command", command.Line)
+		}
+		items = append(items, &yamlv3.Node{
+			Kind: yamlv3.MappingNode,
+			Tag:  "!!map",
+			Content: []*yamlv3.Node{
+				{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "command"},
+				command,
+				{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "stage"},
+				{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: RunStageBeforeRequirements},
+			},
+		})
+	}
+
+	run := mappingValue(build, "run")
+	switch {
+	case run == nil:
+		build.Content = append(build.Content[:index], append([]*yamlv3.Node{
+			{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "run", HeadComment: key.HeadComment},
+			{Kind: yamlv3.SequenceNode, Tag: "!!seq", Content: items},
+		}, build.Content[index:]...)...)
+	case run.Kind == yamlv3.SequenceNode:
+		run.Content = append(items, run.Content...)
+	case run.Tag == "!!null":
+		*run = yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq", Content: items}
+	default:
+		return "", fmt.Errorf("Failed to migrate pre_install in cog.yaml: run must be a list")
+	}
+	return fmt.Sprintf("Moved %d pre_install command(s) to run with stage: %s", len(items), RunStageBeforeRequirements), nil
+}
+
+// mappingIndex returns the index of a key in the content of a mapping node, or -1 if it isn't there
+func mappingIndex(mapping *yamlv3.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of a key in a mapping node, or nil if it isn't there
+func mappingValue(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	if i := mappingIndex(mapping, key); i >= 0 {
+		return mapping.Content[i+1]
+	}
+	return nil
+}
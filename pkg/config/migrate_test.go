@@ -0,0 +1,71 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigratePreInstall(t *testing.T) {
+	migrated, changes, err := Migrate([]byte(`build:
+  python_version: "3.11"
+  # Set up the compiler
+  pre_install:
+    - apt-get update
+    - "pip install -U pip"
+  run:
+    - echo hello # say hello
+predict: "predict.py:Predictor"
+`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Moved 2 pre_install command(s) to run with stage: before_requirements"}, changes)
+	require.Equal(t, `build:
+  python_version: "3.11"
+  run:
+    - command: apt-get update
+      stage: before_requirements
+    - command: "pip install -U pip"
+      stage: before_requirements
+    - echo hello # say hello
+predict: "predict.py:Predictor"
+`, string(migrated))
+
+	config, err := FromYAML(migrated)
+	require.NoError(t, err)
+	require.Empty(t, config.Build.PreInstall)
+	require.Empty(t, config.Deprecations())
+	require.Equal(t, RunItem{Command: "apt-get update", Stage: RunStageBeforeRequirements}, config.Build.Run[0])
+}
+
+func TestMigratePreInstallWithoutRun(t *testing.T) {
+	migrated, changes, err := Migrate([]byte(`build:
+  # Set up the compiler
+  pre_install:
+    - apt-get update
+  python_version: "3.11"
+`))
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, `build:
+  # Set up the compiler
+  run:
+    - command: apt-get update
+      stage: before_requirements
+  python_version: "3.11"
+`, string(migrated))
+}
+
+func TestMigrateNothing(t *testing.T) {
+	contents := []byte("build:\n  python_version: \"3.11\"\n")
+	migrated, changes, err := Migrate(contents)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+	require.Equal(t, contents, migrated)
+}
+
+func TestDeprecations(t *testing.T) {
+	config, err := FromYAML([]byte("build:\n  pre_install:\n    - apt-get update\n"))
+	require.NoError(t, err)
+	require.Len(t, config.Deprecations(), 1)
+	require.Contains(t, config.Deprecations()[0], "cog config migrate")
+}
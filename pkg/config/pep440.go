@@ -0,0 +1,298 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pep440Version is a Python package version as described in https://peps.python.org/pep-0440/
+type pep440Version struct {
+	epoch   int
+	release []int
+	// preKind is a, b or rc, and is empty if this isn't a pre-release
+	preKind string
+	pre     int
+	post    int // -1 if this isn't a post-release
+	dev     int // -1 if this isn't a development release
+	local   []string
+}
+
+// This is the regular expression from https://peps.python.org/pep-0440/#appendix-b-parsing-version-strings-with-regular-expressions
+var pep440VersionRe = regexp.MustCompile(`(?i)^\s*v?` +
+	`(?:(?P<epoch>[0-9]+)!)?` +
+	`(?P<release>[0-9]+(?:\.[0-9]+)*)` +
+	`(?:[-_.]?(?P<pre_l>alpha|a|beta|b|preview|pre|c|rc)[-_.]?(?P<pre_n>[0-9]+)?)?` +
+	`(?:-(?P<post_n1>[0-9]+)|[-_.]?(?P<post_l>post|rev|r)[-_.]?(?P<post_n2>[0-9]+)?)?` +
+	`(?:[-_.]?(?P<dev_l>dev)[-_.]?(?P<dev_n>[0-9]+)?)?` +
+	`(?:\+(?P<local>[a-z0-9]+(?:[-_.][a-z0-9]+)*))?\s*$`)
+
+func parsePEP440Version(s string) (*pep440Version, error) {
+	match := pep440VersionRe.FindStringSubmatch(s)
+	if match == nil {
+		return nil, fmt.Errorf("Invalid version: %s", s)
+	}
+	group := func(name string) string {
+		return match[pep440VersionRe.SubexpIndex(name)]
+	}
+	atoi := func(s string) int {
+		if s == "" {
+			return 0
+		}
+		// The regular expression only matches digits, so this can only fail on overflow
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	v := &pep440Version{post: -1, dev: -1}
+	v.epoch = atoi(group("epoch"))
+	for _, part := range strings.Split(group("release"), ".") {
+		v.release = append(v.release, atoi(part))
+	}
+	if preLabel := strings.ToLower(group("pre_l")); preLabel != "" {
+		switch preLabel {
+		case "alpha", "a":
+			v.preKind = "a"
+		case "beta", "b":
+			v.preKind = "b"
+		default:
+			v.preKind = "rc"
+		}
+		v.pre = atoi(group("pre_n"))
+	}
+	if postN := group("post_n1"); postN != "" {
+		v.post = atoi(postN)
+	} else if group("post_l") != "" {
+		v.post = atoi(group("post_n2"))
+	}
+	if strings.EqualFold(group("dev_l"), "dev") {
+		v.dev = atoi(group("dev_n"))
+	}
+	if local := group("local"); local != "" {
+		v.local = strings.FieldsFunc(strings.ToLower(local), func(r rune) bool {
+			return r == '-' || r == '_' || r == '.'
+		})
+	}
+	return v, nil
+}
+
+func (v *pep440Version) isPrerelease() bool {
+	return v.preKind != "" || v.dev >= 0
+}
+
+// public returns the version without its local version label
+func (v *pep440Version) public() *pep440Version {
+	public := *v
+	public.local = nil
+	return &public
+}
+
+// compare returns -1, 0 or 1 if v is less than, equal to, or greater than other
+func (v *pep440Version) compare(other *pep440Version) int {
+	if c := compareInts(v.epoch, other.epoch); c != 0 {
+		return c
+	}
+	if c := compareReleases(v.release, other.release); c != 0 {
+		return c
+	}
+
+	// Development releases of a release without a pre or post release sort before its pre-releases
+	if c := compareInts(v.preRank(), other.preRank()); c != 0 {
+		return c
+	}
+	if v.preKind != "" {
+		if c := compareInts(v.pre, other.pre); c != 0 {
+			return c
+		}
+	}
+	if c := compareInts(v.post, other.post); c != 0 {
+		return c
+	}
+	// Versions without a dev segment sort after versions that have one
+	vDev, otherDev := v.dev, other.dev
+	if vDev < 0 {
+		vDev = int(^uint(0) >> 1)
+	}
+	if otherDev < 0 {
+		otherDev = int(^uint(0) >> 1)
+	}
+	if c := compareInts(vDev, otherDev); c != 0 {
+		return c
+	}
+	return compareLocals(v.local, other.local)
+}
+
+func (v *pep440Version) preRank() int {
+	switch {
+	case v.preKind == "" && v.post < 0 && v.dev >= 0:
+		return -1
+	case v.preKind == "a":
+		return 0
+	case v.preKind == "b":
+		return 1
+	case v.preKind == "rc":
+		return 2
+	default:
+		return 3
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareReleases compares release segments, treating missing trailing segments as zero
+func compareReleases(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if c := compareInts(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareLocals compares local version labels. Numeric segments sort after alphanumeric ones,
+// and a version with a local label sorts after the same version without one.
+func compareLocals(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, xErr := strconv.Atoi(a[i])
+		y, yErr := strconv.Atoi(b[i])
+		switch {
+		case xErr == nil && yErr == nil:
+			if c := compareInts(x, y); c != 0 {
+				return c
+			}
+		case xErr == nil:
+			return 1
+		case yErr == nil:
+			return -1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+var specifierRe = regexp.MustCompile(`^\s*(~=|===|==|!=|<=|>=|<|>)\s*([^\s,;]+)\s*$`)
+
+func parseSpecifier(s string) (Specifier, error) {
+	match := specifierRe.FindStringSubmatch(s)
+	if match == nil {
+		return Specifier{}, fmt.Errorf("Invalid version specifier: %s", strings.TrimSpace(s))
+	}
+	spec := Specifier{Operator: match[1], Version: match[2]}
+	if spec.Operator == "===" {
+		return spec, nil
+	}
+	version := spec.Version
+	if strings.HasSuffix(version, ".*") {
+		if spec.Operator != "==" && spec.Operator != "!=" {
+			return Specifier{}, fmt.Errorf("Invalid version specifier: %s. Wildcards can only be used with == and !=", s)
+		}
+		version = strings.TrimSuffix(version, ".*")
+	}
+	if _, err := parsePEP440Version(version); err != nil {
+		return Specifier{}, fmt.Errorf("Invalid version specifier: %s", strings.TrimSpace(s))
+	}
+	if spec.Operator == "~=" && !strings.Contains(version, ".") {
+		return Specifier{}, fmt.Errorf("Invalid version specifier: %s. ~= requires at least two version segments", s)
+	}
+	return spec, nil
+}
+
+// Contains returns true if the version satisfies the specifier. A specifier with an invalid version, which
+// ParseRequirement would have rejected, doesn't contain any versions.
+func (s Specifier) Contains(version string) bool {
+	if s.Operator == "===" {
+		return strings.EqualFold(s.Version, version)
+	}
+	candidate, err := parsePEP440Version(version)
+	if err != nil {
+		return false
+	}
+	wildcard := strings.HasSuffix(s.Version, ".*")
+	spec, err := parsePEP440Version(strings.TrimSuffix(s.Version, ".*"))
+	if err != nil || (wildcard && s.Operator != "==" && s.Operator != "!=") {
+		return false
+	}
+
+	switch s.Operator {
+	case "==":
+		return matchesEqual(candidate, spec, wildcard)
+	case "!=":
+		return !matchesEqual(candidate, spec, wildcard)
+	case "~=":
+		prefix := spec.release[:len(spec.release)-1]
+		return candidate.public().compare(spec) >= 0 && hasReleasePrefix(candidate, prefix)
+	}
+
+	c := candidate.public().compare(spec)
+	switch s.Operator {
+	case "<=":
+		return c <= 0
+	case ">=":
+		return c >= 0
+	case "<":
+		// <3.0 doesn't match pre-releases of 3.0, unless the specifier is a pre-release itself
+		return c < 0 && (spec.isPrerelease() || !candidate.isPrerelease() || compareReleases(candidate.release, spec.release) != 0)
+	case ">":
+		// >3.0 doesn't match post-releases of 3.0
+		return c > 0 && (candidate.post < 0 || compareReleases(candidate.release, spec.release) != 0)
+	}
+	return false
+}
+
+// matchesEqual returns true if the candidate matches ==spec, or ==spec.* if wildcard is set
+func matchesEqual(candidate *pep440Version, spec *pep440Version, wildcard bool) bool {
+	if wildcard {
+		return candidate.epoch == spec.epoch && hasReleasePrefix(candidate, spec.release)
+	}
+	if len(spec.local) == 0 {
+		// ==2.1.0 matches 2.1.0+cu118
+		candidate = candidate.public()
+	}
+	return candidate.compare(spec) == 0
+}
+
+func hasReleasePrefix(v *pep440Version, prefix []int) bool {
+	for i, n := range prefix {
+		segment := 0
+		if i < len(v.release) {
+			segment = v.release[i]
+		}
+		if segment != n {
+			return false
+		}
+	}
+	return true
+}
+
+// pep440Greater returns true if a is a greater version than b. Invalid versions are never greater.
+func pep440Greater(a string, b string) bool {
+	aVersion, err := parsePEP440Version(a)
+	if err != nil {
+		return false
+	}
+	bVersion, err := parsePEP440Version(b)
+	if err != nil {
+		return true
+	}
+	return aVersion.compare(bVersion) > 0
+}
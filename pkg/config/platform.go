@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultPlatform is the platform images are built for when build.platforms isn't set
+const DefaultPlatform = "linux/amd64"
+
+// platformLibDirs are the Debian multiarch library directories for each architecture Cog builds for
+var platformLibDirs = map[string]string{
+	"amd64": "/usr/lib/x86_64-linux-gnu",
+	"arm64": "/usr/lib/aarch64-linux-gnu",
+}
+
+// Platforms returns the platforms to build the image for, in the form os/arch
+func (c *Config) Platforms() []string {
+	if len(c.Build.Platforms) == 0 {
+		return []string{DefaultPlatform}
+	}
+	return c.Build.Platforms
+}
+
+// ParsePlatform splits a platform like linux/arm64 into its OS and architecture
+func ParsePlatform(platform string) (goos string, goarch string, err error) {
+	goos, goarch, ok := strings.Cut(platform, "/")
+	if !ok || goos != "linux" {
+		return "", "", fmt.Errorf("Invalid platform %q. Platforms must be in the form linux/<architecture>, like linux/amd64", platform)
+	}
+	if _, ok := platformLibDirs[goarch]; !ok {
+		return "", "", fmt.Errorf("Unsupported platform %q. Cog can build images for: %s", platform, strings.Join(SupportedPlatforms(), ", "))
+	}
+	return goos, goarch, nil
+}
+
+// SupportedPlatforms returns the platforms Cog can build images for
+func SupportedPlatforms() []string {
+	return []string{"linux/amd64", "linux/arm64"}
+}
+
+// PlatformLibDir returns the directory system libraries are installed in for an architecture
+func PlatformLibDir(goarch string) string {
+	return platformLibDirs[goarch]
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatform(t *testing.T) {
+	goos, goarch, err := ParsePlatform("linux/arm64")
+	require.NoError(t, err)
+	require.Equal(t, "linux", goos)
+	require.Equal(t, "arm64", goarch)
+
+	_, _, err = ParsePlatform("darwin/arm64")
+	require.ErrorContains(t, err, `Invalid platform "darwin/arm64"`)
+
+	_, _, err = ParsePlatform("linux/s390x")
+	require.ErrorContains(t, err, `Unsupported platform "linux/s390x". Cog can build images for: linux/amd64, linux/arm64`)
+}
+
+func TestPlatformsDefault(t *testing.T) {
+	config := &Config{Build: &Build{}}
+	require.Equal(t, []string{"linux/amd64"}, config.Platforms())
+}
+
+func TestValidateAndCompleteInvalidPlatform(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.10",
+			Platforms:     []string{"linux/amd64", "windows/amd64"},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.ErrorContains(t, err, `Invalid platform "windows/amd64"`)
+}
+
+func TestTorchCPUPackageForLinuxArm64(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.10",
+			PythonPackages: []string{
+				"torch==2.3.1",
+			},
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+
+	requirements, err := config.PythonRequirementsForArch("linux", "arm64", []string{})
+	require.NoError(t, err)
+	require.NotContains(t, requirements, "+cpu")
+	require.Contains(t, requirements, "torch==2.3.1")
+}
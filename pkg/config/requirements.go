@@ -0,0 +1,260 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Requirement is a single line of a requirements.txt file, as described in
+// https://pip.pypa.io/en/stable/reference/requirements-file-format/
+type Requirement struct {
+	Name       string
+	Extras     []string
+	Specifiers []Specifier
+	// URL is set for direct references, like name @ https://example.com/name.whl
+	URL    string
+	Marker string
+	Hashes []string
+	// Options are any other per-requirement options, like --config-settings, as they were written
+	Options        []string
+	FindLinks      []string
+	ExtraIndexURLs []string
+}
+
+// Specifier is a single version clause of a requirement, such as >=2.1
+type Specifier struct {
+	Operator string
+	Version  string
+}
+
+func (s Specifier) String() string {
+	return s.Operator + s.Version
+}
+
+// Options that are followed by a value when they aren't written as --option=value
+var requirementOptionsWithValues = map[string]bool{
+	"-f":                true,
+	"--find-links":      true,
+	"-i":                true,
+	"--index-url":       true,
+	"--extra-index-url": true,
+	"-r":                true,
+	"--requirement":     true,
+	"-c":                true,
+	"--constraint":      true,
+	"-e":                true,
+	"--editable":        true,
+	"--hash":            true,
+	"--trusted-host":    true,
+	"--config-settings": true,
+	"--global-option":   true,
+	"--install-option":  true,
+	"--no-binary":       true,
+	"--only-binary":     true,
+	"--use-feature":     true,
+}
+
+var (
+	requirementCommentRe = regexp.MustCompile(`(^|\s)#.*$`)
+	requirementNameRe    = regexp.MustCompile(`^([A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?)\s*(?:\[([^\]]*)\])?\s*(.*)$`)
+)
+
+// ParseRequirement parses a line of a requirements.txt file.
+//
+// It returns nil if the line doesn't name a package, such as a comment, an option like
+// --extra-index-url, an editable install, or a path to a wheel.
+func ParseRequirement(line string) (*Requirement, error) {
+	line = requirementCommentRe.ReplaceAllString(line, "")
+	line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), "\\"))
+	if line == "" {
+		return nil, nil
+	}
+
+	req := &Requirement{}
+	specTokens := []string{}
+	named := true
+	tokens := strings.Fields(line)
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if !strings.HasPrefix(token, "-") {
+			specTokens = append(specTokens, token)
+			continue
+		}
+
+		option, value, hasValue := strings.Cut(token, "=")
+		if !hasValue && requirementOptionsWithValues[option] {
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("Option %s in %q is missing a value", option, line)
+			}
+			i++
+			value = tokens[i]
+		}
+		switch option {
+		case "-f", "--find-links":
+			req.FindLinks = append(req.FindLinks, value)
+		case "--extra-index-url":
+			req.ExtraIndexURLs = append(req.ExtraIndexURLs, value)
+		case "--hash":
+			req.Hashes = append(req.Hashes, value)
+		case "-r", "--requirement", "-c", "--constraint", "-e", "--editable":
+			// These reference other requirements that Cog can't inspect
+			named = false
+		default:
+			switch {
+			case !hasValue && !requirementOptionsWithValues[option]:
+				req.Options = append(req.Options, option)
+			case strings.HasPrefix(option, "--"):
+				req.Options = append(req.Options, option+"="+value)
+			default:
+				req.Options = append(req.Options, option+" "+value)
+			}
+		}
+	}
+
+	if !named || len(specTokens) == 0 {
+		return nil, nil
+	}
+	spec := strings.Join(specTokens, " ")
+	if isRequirementPathOrURL(spec) {
+		return nil, nil
+	}
+
+	if before, marker, found := strings.Cut(spec, ";"); found {
+		spec = strings.TrimSpace(before)
+		req.Marker = strings.TrimSpace(marker)
+		if req.Marker == "" {
+			return nil, fmt.Errorf("Failed to parse Python requirement %q: the environment marker after ; is empty", line)
+		}
+	}
+
+	match := requirementNameRe.FindStringSubmatch(spec)
+	if match == nil {
+		return nil, fmt.Errorf("Failed to parse Python requirement %q", line)
+	}
+	req.Name = match[1]
+	if match[2] != "" {
+		for _, extra := range strings.Split(match[2], ",") {
+			if extra = strings.TrimSpace(extra); extra != "" {
+				req.Extras = append(req.Extras, extra)
+			}
+		}
+	}
+
+	rest := strings.TrimSpace(match[3])
+	if strings.HasPrefix(rest, "@") {
+		req.URL = strings.TrimSpace(strings.TrimPrefix(rest, "@"))
+		if req.URL == "" {
+			return nil, fmt.Errorf("Failed to parse Python requirement %q: the URL after @ is empty", line)
+		}
+		return req, nil
+	}
+	if strings.HasPrefix(rest, "(") && strings.HasSuffix(rest, ")") {
+		rest = rest[1 : len(rest)-1]
+	}
+	if strings.TrimSpace(rest) == "" {
+		return req, nil
+	}
+	for _, clause := range strings.Split(rest, ",") {
+		specifier, err := parseSpecifier(clause)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse Python requirement %q: %w", line, err)
+		}
+		req.Specifiers = append(req.Specifiers, specifier)
+	}
+	return req, nil
+}
+
+// isRequirementPathOrURL returns true for requirements that point directly at a file or URL, like ./my_package or https://example.com/package.whl
+func isRequirementPathOrURL(spec string) bool {
+	if strings.HasPrefix(spec, ".") || strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "~") {
+		return true
+	}
+	if before, _, found := strings.Cut(spec, "://"); found && !strings.Contains(before, "@") {
+		return true
+	}
+	return false
+}
+
+// Version returns the version the requirement is pinned to with ==, or an empty string if it isn't pinned to an exact version
+func (r *Requirement) Version() string {
+	if len(r.Specifiers) != 1 {
+		return ""
+	}
+	spec := r.Specifiers[0]
+	if (spec.Operator != "==" && spec.Operator != "===") || strings.HasSuffix(spec.Version, ".*") {
+		return ""
+	}
+	return spec.Version
+}
+
+// Pin replaces the version specifiers of the requirement with ==version
+func (r *Requirement) Pin(version string) {
+	r.Specifiers = []Specifier{{Operator: "==", Version: version}}
+}
+
+// Contains returns true if the version satisfies all of the requirement's version specifiers
+func (r *Requirement) Contains(version string) bool {
+	for _, spec := range r.Specifiers {
+		if !spec.Contains(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// BestMatch returns the greatest of the candidate versions that satisfies the requirement, or an empty string if none do.
+// Pre-releases are only considered if the requirement is pinned to one.
+func (r *Requirement) BestMatch(candidates []string) string {
+	best := ""
+	for _, candidate := range candidates {
+		v, err := parsePEP440Version(candidate)
+		if err != nil || (v.isPrerelease() && !r.allowsPrereleases()) || !r.Contains(candidate) {
+			continue
+		}
+		if best == "" || pep440Greater(candidate, best) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+func (r *Requirement) allowsPrereleases() bool {
+	for _, spec := range r.Specifiers {
+		if v, err := parsePEP440Version(strings.TrimSuffix(spec.Version, ".*")); err == nil && v.isPrerelease() {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the requirement in requirements.txt format. Index options are left out,
+// because pip only reads them at the top level of requirements.txt.
+func (r *Requirement) String() string {
+	s := r.Name
+	if len(r.Extras) > 0 {
+		s += "[" + strings.Join(r.Extras, ",") + "]"
+	}
+	if r.URL != "" {
+		s += " @ " + r.URL
+	}
+	specs := []string{}
+	for _, spec := range r.Specifiers {
+		specs = append(specs, spec.String())
+	}
+	s += strings.Join(specs, ",")
+	if r.Marker != "" {
+		if r.URL != "" {
+			// A space is required between a URL and the marker, so the ; isn't read as part of the URL
+			s += " "
+		}
+		s += "; " + r.Marker
+	}
+	for _, hash := range r.Hashes {
+		s += " --hash=" + hash
+	}
+	for _, option := range r.Options {
+		s += " " + option
+	}
+	return s
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequirement(t *testing.T) {
+	for _, tt := range []struct {
+		line     string
+		expected *Requirement
+	}{
+		{"", nil},
+		{"# a comment", nil},
+		{"--extra-index-url https://example.com/simple", nil},
+		{"-r other-requirements.txt", nil},
+		{"-e git+https://github.com/replicate/cog.git#egg=cog", nil},
+		{"./local_package", nil},
+		{"https://example.com/package-1.0.0-py3-none-any.whl", nil},
+		{"torch==2.1.0", &Requirement{Name: "torch", Specifiers: []Specifier{{"==", "2.1.0"}}}},
+		{"zope.interface==6.1", &Requirement{Name: "zope.interface", Specifiers: []Specifier{{"==", "6.1"}}}},
+		{"torch >= 2.1, < 2.3  # comment", &Requirement{Name: "torch", Specifiers: []Specifier{{">=", "2.1"}, {"<", "2.3"}}}},
+		{"fastapi~=0.100", &Requirement{Name: "fastapi", Specifiers: []Specifier{{"~=", "0.100"}}}},
+		{"requests (>=2.0)", &Requirement{Name: "requests", Specifiers: []Specifier{{">=", "2.0"}}}},
+		{"jax[cuda12, tpu]==0.4.30", &Requirement{Name: "jax", Extras: []string{"cuda12", "tpu"}, Specifiers: []Specifier{{"==", "0.4.30"}}}},
+		{`numpy<2; python_version < "3.9"`, &Requirement{Name: "numpy", Specifiers: []Specifier{{"<", "2"}}, Marker: `python_version < "3.9"`}},
+		{"cog @ https://example.com/cog.whl ; sys_platform == 'linux'", &Requirement{Name: "cog", URL: "https://example.com/cog.whl", Marker: "sys_platform == 'linux'"}},
+		{"six==1.16.0 --hash=sha256:abc --hash sha256:def \\", &Requirement{Name: "six", Specifiers: []Specifier{{"==", "1.16.0"}}, Hashes: []string{"sha256:abc", "sha256:def"}}},
+		{"-f https://example.com/links pillow", &Requirement{Name: "pillow", FindLinks: []string{"https://example.com/links"}}},
+		{"foo==1.0 --config-settings key=value --pre", &Requirement{Name: "foo", Specifiers: []Specifier{{"==", "1.0"}}, Options: []string{"--config-settings=key=value", "--pre"}}},
+	} {
+		req, err := ParseRequirement(tt.line)
+		require.NoError(t, err, tt.line)
+		require.Equal(t, tt.expected, req, tt.line)
+	}
+}
+
+func TestParseRequirementErrors(t *testing.T) {
+	for _, line := range []string{
+		"!!!",
+		"torch==",
+		"torch=>2.0",
+		"torch>=2.*",
+		"torch~=2",
+		"torch; ",
+		"torch==2.1.0 --hash",
+	} {
+		_, err := ParseRequirement(line)
+		require.Error(t, err, line)
+	}
+}
+
+func TestRequirementString(t *testing.T) {
+	for _, line := range []string{
+		"torch==2.1.0",
+		"jax[cuda12]==0.4.30",
+		"torch>=2.1,<2.3; sys_platform == 'linux'",
+		"cog @ https://example.com/cog.whl ; sys_platform == 'linux'",
+		"six==1.16.0 --hash=sha256:abc",
+	} {
+		req, err := ParseRequirement(line)
+		require.NoError(t, err)
+		require.Equal(t, line, req.String())
+	}
+}
+
+func TestSpecifierContains(t *testing.T) {
+	for _, tt := range []struct {
+		specifier string
+		version   string
+		expected  bool
+	}{
+		{"==2.1.0", "2.1.0", true},
+		{"==2.1", "2.1.0", true},
+		{"==2.1.0", "2.1.0+cu118", true},
+		{"==2.1.0+cu118", "2.1.0+cu121", false},
+		{"==2.1.*", "2.1.2", true},
+		{"==2.1.*", "2.10.0", false},
+		{"!=2.1.*", "2.2.0", true},
+		{">=2.1", "2.1.0", true},
+		{">=2.1", "2.0.1", false},
+		{"<2.3", "2.2.2", true},
+		{"<2.3", "2.3.0", false},
+		{"<2.3", "2.3.0rc1", false},
+		{"<2.3rc2", "2.3.0rc1", true},
+		{">2.1", "2.1.0.post1", false},
+		{">2.1", "2.1.1", true},
+		{"~=2.1", "2.9.0", true},
+		{"~=2.1", "3.0.0", false},
+		{"~=2.1.0", "2.1.5", true},
+		{"~=2.1.0", "2.2.0", false},
+		{"<=1.0", "1.0.0+local", true},
+		{"===1.0", "1.0", true},
+		{">=1.0", "not-a-version", false},
+	} {
+		spec, err := parseSpecifier(tt.specifier)
+		require.NoError(t, err, tt.specifier)
+		require.Equal(t, tt.expected, spec.Contains(tt.version), tt.specifier+" contains "+tt.version)
+	}
+}
+
+func TestSpecifierContainsInvalidVersion(t *testing.T) {
+	// Specifiers can be made without ParseRequirement, so their versions may not be valid
+	for _, operator := range []string{"==", "!=", "~=", ">=", "<=", ">", "<"} {
+		require.False(t, Specifier{Operator: operator, Version: "abc"}.Contains("1.0"), operator)
+	}
+	require.False(t, Specifier{Operator: "==", Version: "abc.*"}.Contains("1.0"))
+	require.False(t, Specifier{Operator: ">=", Version: "1.*"}.Contains("1.0"))
+}
+
+func TestPEP440Ordering(t *testing.T) {
+	// Each version is greater than the one before it
+	ordered := []string{
+		"1.0.dev1",
+		"1.0a1",
+		"1.0a2.dev1",
+		"1.0a2",
+		"1.0b1",
+		"1.0rc1",
+		"1.0",
+		"1.0+abc",
+		"1.0+1",
+		"1.0.post1",
+		"1.1",
+		"1.10",
+		"1!0.1",
+	}
+	for i := 1; i < len(ordered); i++ {
+		require.True(t, pep440Greater(ordered[i], ordered[i-1]), ordered[i]+" > "+ordered[i-1])
+		require.False(t, pep440Greater(ordered[i-1], ordered[i]), ordered[i-1]+" > "+ordered[i])
+	}
+}
+
+func TestRequirementBestMatch(t *testing.T) {
+	req, err := ParseRequirement("torch>=2.1,<2.3")
+	require.NoError(t, err)
+	require.Equal(t, "2.2.2", req.BestMatch([]string{"2.0.1", "2.1.0", "2.2.2", "2.3.0rc1", "2.3.1"}))
+
+	req, err = ParseRequirement("torch>=3.0")
+	require.NoError(t, err)
+	require.Equal(t, "", req.BestMatch([]string{"2.1.0", "3.0.0rc1"}))
+}
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/replicate/cog/pkg/util/version"
+)
+
+// PackageResolver rewrites a Python requirement to the build that works on the target platform,
+// for example the torch wheel that was built for the CUDA version in the config.
+//
+// Resolvers that don't need to change the requirement should return it unchanged.
+type PackageResolver func(c *Config, req Requirement, goos string, goarch string) (Requirement, error)
+
+var packageResolvers = map[string]PackageResolver{}
+
+// RegisterPackageResolver sets the resolver for a Python package, replacing any existing resolver for it
+func RegisterPackageResolver(name string, resolver PackageResolver) {
+	packageResolvers[normalizePackageName(name)] = resolver
+}
+
+func packageResolverFor(name string) (PackageResolver, bool) {
+	resolver, ok := packageResolvers[normalizePackageName(name)]
+	return resolver, ok
+}
+
+var packageNameSeparatorRe = regexp.MustCompile(`[-_.]+`)
+
+// normalizePackageName normalizes a package name as described in PEP 503, so Foo_Bar and foo-bar are the same package
+func normalizePackageName(name string) string {
+	return packageNameSeparatorRe.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+func init() {
+	RegisterPackageResolver("tensorflow", resolveTensorflow)
+	RegisterPackageResolver("torch", resolveTorch)
+	RegisterPackageResolver("torchvision", resolveTorchvision)
+	RegisterPackageResolver("torchaudio", resolveTorchaudio)
+	RegisterPackageResolver("xformers", resolveXformers)
+	RegisterPackageResolver("jax", resolveJax)
+	RegisterPackageResolver("jaxlib", resolveJaxlib)
+	RegisterPackageResolver("onnxruntime-gpu", resolveOnnxruntimeGPU)
+	RegisterPackageResolver("paddlepaddle-gpu", resolvePaddlepaddleGPU)
+}
+
+func resolveTensorflow(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	// There is no CPU case for tensorflow because the default package is just the CPU package, so no transformation of version is needed
+	if !c.Build.GPU {
+		return req, nil
+	}
+	tfVersion, ok := resolvedVersion(req, c.TensorFlowVersion)
+	if !ok {
+		return req, nil
+	}
+	name, version, err := tfGPUPackage(tfVersion, c.Build.CUDA)
+	if err != nil {
+		return req, err
+	}
+	if name == "" {
+		return req, nil
+	}
+	req.Name = name
+	req.Pin(version)
+	return req, nil
+}
+
+func resolveTorch(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	torchVersion, ok := resolvedVersion(req, c.TorchVersion)
+	if !ok {
+		return req, nil
+	}
+	if c.Build.GPU {
+		return withPackage(req)(torchGPUPackage(torchVersion, c.Build.CUDA))
+	}
+	return withPackage(req)(torchCPUPackage(torchVersion, goos, goarch))
+}
+
+func resolveTorchvision(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	torchvisionVersion, ok := resolvedVersion(req, c.TorchvisionVersion)
+	if !ok {
+		return req, nil
+	}
+	if c.Build.GPU {
+		return withPackage(req)(torchvisionGPUPackage(torchvisionVersion, c.Build.CUDA))
+	}
+	return withPackage(req)(torchvisionCPUPackage(torchvisionVersion, goos, goarch))
+}
+
+func resolveTorchaudio(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	torchaudioVersion, ok := resolvedVersion(req, func() (string, bool) {
+		torchVersion, ok := c.TorchVersion()
+		if !ok {
+			return "", false
+		}
+		best := req.BestMatch(torchaudiosFromTorch(torchVersion))
+		return best, best != ""
+	})
+	if !ok {
+		return req, nil
+	}
+	if c.Build.GPU {
+		return withPackage(req)(torchaudioGPUPackage(torchaudioVersion, c.Build.CUDA))
+	}
+	return withPackage(req)(torchaudioCPUPackage(torchaudioVersion, goos, goarch))
+}
+
+// resolvedVersion returns the version a requirement is pinned to. Version ranges are resolved
+// with fromRange, so the package is pinned to a version Cog has a build for.
+func resolvedVersion(req Requirement, fromRange func() (string, bool)) (string, bool) {
+	if version := req.Version(); version != "" {
+		return version, true
+	}
+	if len(req.Specifiers) == 0 {
+		return "", false
+	}
+	return fromRange()
+}
+
+// withPackage returns a function that sets the requirement to the output of one of the *Package functions in compatibility.go
+func withPackage(req Requirement) func(name, version, findLinks, extraIndexURL string, err error) (Requirement, error) {
+	return func(name, version, findLinks, extraIndexURL string, err error) (Requirement, error) {
+		if err != nil {
+			return req, err
+		}
+		req.Name = name
+		req.Pin(version)
+		return withIndexes(req, findLinks, extraIndexURL), nil
+	}
+}
+
+// resolveXformers installs xformers from the PyTorch index for the configured CUDA version,
+// because the xformers wheels on PyPI are only built for the latest CUDA
+func resolveXformers(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	if !c.Build.GPU {
+		return req, nil
+	}
+	torchVersion, _ := c.TorchVersion()
+	var latest *TorchCompatibility
+	if torchVersion != "" {
+		latest = latestTorchCompatibilityForCUDA(c.Build.CUDA, func(compat *TorchCompatibility) bool {
+			return compat.TorchVersion() == torchVersion && compat.ExtraIndexURL != ""
+		})
+	}
+	if latest == nil {
+		latest = latestTorchCompatibilityForCUDA(c.Build.CUDA, func(compat *TorchCompatibility) bool {
+			return compat.ExtraIndexURL != ""
+		})
+	}
+	if latest == nil {
+		return req, nil
+	}
+	return withIndexes(req, "", latest.ExtraIndexURL), nil
+}
+
+const (
+	jaxCUDAReleasesURL = "https://storage.googleapis.com/jax-releases/jax_cuda_releases.html"
+	// From this version, jax installs CUDA from pip with the jax[cuda12] extra, and jaxlib is no longer CUDA specific
+	jaxCUDAPluginVersion = "0.4.26"
+)
+
+func resolveJax(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	if !c.Build.GPU || len(req.Extras) > 0 {
+		return req, nil
+	}
+	cuda, err := version.NewVersion(c.Build.CUDA)
+	if err != nil {
+		return req, nil
+	}
+	jaxVersion, err := version.NewVersion(req.Version())
+	if err != nil {
+		return req, nil
+	}
+	if jaxVersion.GreaterOrEqual(version.MustVersion(jaxCUDAPluginVersion)) {
+		if cuda.Major < 12 {
+			return req, fmt.Errorf("jax==%s requires CUDA 12 or later, but CUDA %s is set in cog.yaml", req.Version(), c.Build.CUDA)
+		}
+		req.Extras = []string{fmt.Sprintf("cuda%d", cuda.Major)}
+		return req, nil
+	}
+	req.Extras = []string{fmt.Sprintf("cuda%d_pip", cuda.Major)}
+	return withIndexes(req, jaxCUDAReleasesURL, ""), nil
+}
+
+func resolveJaxlib(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	if !c.Build.GPU {
+		return req, nil
+	}
+	jaxlibVersion, err := version.NewVersion(req.Version())
+	if err != nil || jaxlibVersion.GreaterOrEqual(version.MustVersion(jaxCUDAPluginVersion)) {
+		return req, nil
+	}
+	return withIndexes(req, jaxCUDAReleasesURL, ""), nil
+}
+
+const (
+	onnxruntimeCUDA11IndexURL = "https://aiinfra.pkgs.visualstudio.com/PublicPackages/_packaging/onnxruntime-cuda-11/pypi/simple/"
+	onnxruntimeCUDA12IndexURL = "https://aiinfra.pkgs.visualstudio.com/PublicPackages/_packaging/onnxruntime-cuda-12/pypi/simple/"
+)
+
+// resolveOnnxruntimeGPU picks the onnxruntime-gpu index for the configured CUDA version.
+// From 1.17 to 1.18 the PyPI package is built for CUDA 11.8, and from 1.19 it is built for CUDA 12.
+func resolveOnnxruntimeGPU(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	if !c.Build.GPU {
+		return req, nil
+	}
+	cuda, err := version.NewVersion(c.Build.CUDA)
+	if err != nil {
+		return req, nil
+	}
+	ortVersion, err := version.NewVersion(req.Version())
+	if err != nil {
+		return req, nil
+	}
+	pypiCUDAMajor := 11
+	if ortVersion.GreaterOrEqual(version.MustVersion("1.19.0")) {
+		pypiCUDAMajor = 12
+	}
+	switch {
+	case cuda.Major == pypiCUDAMajor:
+		return req, nil
+	case cuda.Major == 12 && ortVersion.GreaterOrEqual(version.MustVersion("1.17.0")):
+		return withIndexes(req, "", onnxruntimeCUDA12IndexURL), nil
+	case cuda.Major == 11 && pypiCUDAMajor == 12:
+		return withIndexes(req, "", onnxruntimeCUDA11IndexURL), nil
+	}
+	return req, nil
+}
+
+const (
+	paddleFindLinksURL = "https://www.paddlepaddle.org.cn/whl/linux/mkl/avx/stable.html"
+	paddleIndexURL     = "https://www.paddlepaddle.org.cn/packages/stable/"
+)
+
+// CUDA versions that paddlepaddle-gpu publishes builds for. Before 3.0, builds are selected with a
+// .postXXX version suffix. From 3.0, each CUDA version has its own index.
+var (
+	paddleV2CUDAs = []string{"10.2", "11.2", "11.6", "11.7", "11.8", "12.0"}
+	paddleV3CUDAs = []string{"11.8", "12.3", "12.6"}
+)
+
+func resolvePaddlepaddleGPU(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+	if !c.Build.GPU {
+		return req, nil
+	}
+	paddleVersion, err := version.NewVersion(req.Version())
+	if err != nil || paddleVersion.Metadata != "" || strings.Contains(req.Version(), ".post") {
+		// Already pinned to a build
+		return req, nil
+	}
+
+	cudas := paddleV2CUDAs
+	if paddleVersion.Major >= 3 {
+		cudas = paddleV3CUDAs
+	}
+	cuda := ""
+	for _, candidate := range cudas {
+		if !version.Greater(candidate, c.Build.CUDA) && (cuda == "" || version.Greater(candidate, cuda)) {
+			cuda = candidate
+		}
+	}
+	if cuda == "" {
+		return req, fmt.Errorf("paddlepaddle-gpu==%s doesn't have a build for CUDA %s. Builds are available for CUDA %s", req.Version(), c.Build.CUDA, strings.Join(cudas, ", "))
+	}
+	cudaTag := strings.ReplaceAll(cuda, ".", "")
+
+	if paddleVersion.Major >= 3 {
+		return withIndexes(req, "", paddleIndexURL+"cu"+cudaTag+"/"), nil
+	}
+	req.Pin(req.Version() + ".post" + cudaTag)
+	return withIndexes(req, paddleFindLinksURL, ""), nil
+}
+
+// withIndexes replaces the indexes of a requirement with the ones given, if any
+func withIndexes(req Requirement, findLinks string, extraIndexURL string) Requirement {
+	if findLinks != "" {
+		req.FindLinks = []string{findLinks}
+	}
+	if extraIndexURL != "" {
+		req.ExtraIndexURLs = []string{extraIndexURL}
+	}
+	return req
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPythonPackagesForArchTorchaudioGPU(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.10",
+			PythonPackages: []string{
+				"torch==2.1.0",
+				"torchaudio==2.1.0",
+			},
+			CUDA: "11.8",
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.NoError(t, err)
+
+	requirements, err := config.PythonRequirementsForArch("", "", []string{})
+	require.NoError(t, err)
+	expected := `--extra-index-url https://download.pytorch.org/whl/cu118
+torch==2.1.0
+torchaudio==2.1.0`
+	require.Equal(t, expected, requirements)
+}
+
+func TestPythonPackagesForArchTorchaudioCPU(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			PythonVersion: "3.10",
+			PythonPackages: []string{
+				"torchaudio==0.13.1",
+			},
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.NoError(t, err)
+
+	requirements, err := config.PythonRequirementsForArch("", "", []string{})
+	require.NoError(t, err)
+	expected := `--extra-index-url https://download.pytorch.org/whl/cpu
+torchaudio==0.13.1`
+	require.Equal(t, expected, requirements)
+}
+
+func TestPythonPackagesForArchXformers(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			PythonPackages: []string{
+				"torch==2.3.1",
+				"xformers==0.0.27",
+			},
+			CUDA: "11.8",
+		},
+	}
+	err := config.ValidateAndComplete("")
+	require.NoError(t, err)
+
+	requirements, err := config.PythonRequirementsForArch("", "", []string{})
+	require.NoError(t, err)
+	expected := `--extra-index-url https://download.pytorch.org/whl/cu118
+torch==2.3.1+cu118
+xformers==0.0.27`
+	require.Equal(t, expected, requirements)
+}
+
+func TestPythonPackagesForArchJax(t *testing.T) {
+	for _, tt := range []struct {
+		requirement string
+		cuda        string
+		expected    string
+	}{
+		{"jax==0.4.30", "12.1", "jax[cuda12]==0.4.30"},
+		{"jax==0.4.20", "12.1", "--find-links " + jaxCUDAReleasesURL + "\njax[cuda12_pip]==0.4.20"},
+		{"jax==0.4.20", "11.8", "--find-links " + jaxCUDAReleasesURL + "\njax[cuda11_pip]==0.4.20"},
+		{"jaxlib==0.4.20", "12.1", "--find-links " + jaxCUDAReleasesURL + "\njaxlib==0.4.20"},
+		{"jaxlib==0.4.30", "12.1", "jaxlib==0.4.30"},
+	} {
+		config := &Config{
+			Build: &Build{
+				GPU:            true,
+				PythonVersion:  "3.11",
+				PythonPackages: []string{tt.requirement},
+				CUDA:           tt.cuda,
+			},
+		}
+		require.NoError(t, config.ValidateAndComplete(""))
+		requirements, err := config.PythonRequirementsForArch("", "", []string{})
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, requirements, tt.requirement+" with CUDA "+tt.cuda)
+	}
+}
+
+func TestPythonPackagesForArchJaxCUDA11Unsupported(t *testing.T) {
+	config := &Config{
+		Build: &Build{
+			GPU:            true,
+			PythonVersion:  "3.11",
+			PythonPackages: []string{"jax==0.4.30"},
+			CUDA:           "11.8",
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+	_, err := config.PythonRequirementsForArch("", "", []string{})
+	require.ErrorContains(t, err, "jax==0.4.30 requires CUDA 12 or later")
+}
+
+func TestPythonPackagesForArchOnnxruntimeGPU(t *testing.T) {
+	for _, tt := range []struct {
+		requirement string
+		cuda        string
+		expected    string
+	}{
+		{"onnxruntime-gpu==1.18.1", "11.8", "onnxruntime-gpu==1.18.1"},
+		{"onnxruntime-gpu==1.18.1", "12.1", "--extra-index-url " + onnxruntimeCUDA12IndexURL + "\nonnxruntime-gpu==1.18.1"},
+		{"onnxruntime_gpu==1.19.0", "12.1", "onnxruntime_gpu==1.19.0"},
+		{"onnxruntime-gpu==1.19.0", "11.8", "--extra-index-url " + onnxruntimeCUDA11IndexURL + "\nonnxruntime-gpu==1.19.0"},
+		{"onnxruntime-gpu==1.16.0", "12.1", "onnxruntime-gpu==1.16.0"},
+	} {
+		config := &Config{
+			Build: &Build{
+				GPU:            true,
+				PythonVersion:  "3.11",
+				PythonPackages: []string{tt.requirement},
+				CUDA:           tt.cuda,
+			},
+		}
+		require.NoError(t, config.ValidateAndComplete(""))
+		requirements, err := config.PythonRequirementsForArch("", "", []string{})
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, requirements, tt.requirement+" with CUDA "+tt.cuda)
+	}
+}
+
+func TestPythonPackagesForArchPaddlepaddleGPU(t *testing.T) {
+	for _, tt := range []struct {
+		requirement string
+		cuda        string
+		expected    string
+	}{
+		{"paddlepaddle-gpu==2.6.1", "11.8", "--find-links " + paddleFindLinksURL + "\npaddlepaddle-gpu==2.6.1.post118"},
+		{"paddlepaddle-gpu==2.6.1", "12.1", "--find-links " + paddleFindLinksURL + "\npaddlepaddle-gpu==2.6.1.post120"},
+		{"paddlepaddle-gpu==2.6.1.post117", "12.1", "paddlepaddle-gpu==2.6.1.post117"},
+		{"paddlepaddle-gpu==3.0.0", "12.3", "--extra-index-url " + paddleIndexURL + "cu123/\npaddlepaddle-gpu==3.0.0"},
+	} {
+		config := &Config{
+			Build: &Build{
+				GPU:            true,
+				PythonVersion:  "3.11",
+				PythonPackages: []string{tt.requirement},
+				CUDA:           tt.cuda,
+			},
+		}
+		require.NoError(t, config.ValidateAndComplete(""))
+		requirements, err := config.PythonRequirementsForArch("", "", []string{})
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, requirements, tt.requirement+" with CUDA "+tt.cuda)
+	}
+}
+
+func TestRegisterPackageResolver(t *testing.T) {
+	RegisterPackageResolver("My_Package", func(c *Config, req Requirement, goos string, goarch string) (Requirement, error) {
+		req.Pin(req.Version() + "+" + goarch)
+		return withIndexes(req, "", "https://example.com/simple"), nil
+	})
+	defer delete(packageResolvers, "my-package")
+
+	config := &Config{
+		Build: &Build{
+			PythonVersion:  "3.11",
+			PythonPackages: []string{"my.package==1.0.0"},
+		},
+	}
+	require.NoError(t, config.ValidateAndComplete(""))
+	requirements, err := config.PythonRequirementsForArch("linux", "arm64", []string{})
+	require.NoError(t, err)
+	require.Equal(t, "--extra-index-url https://example.com/simple\nmy.package==1.0.0+arm64", requirements)
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Values of stage in build.run items
+const (
+	// RunStageBeforeRequirements runs the command after system packages are installed, before Python packages
+	RunStageBeforeRequirements = "before_requirements"
+	// RunStageAfterRequirements runs the command after Python packages are installed. It is the default.
+	RunStageAfterRequirements = "after_requirements"
+)
+
+// Types of mounts in build.run items
+const (
+	RunMountSecret = "secret"
+	RunMountCache  = "cache"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// RunMount is a BuildKit mount the command in a build.run item is run with. Secrets are only mounted if they
+// are given to the build, and caches are kept between builds.
+type RunMount struct {
+	Type   string `json:"type,omitempty" yaml:"type"`
+	ID     string `json:"id,omitempty" yaml:"id"`
+	Target string `json:"target,omitempty" yaml:"target"`
+}
+
+// Flag returns the mount as a RUN --mount flag
+func (m RunMount) Flag() string {
+	flag := "--mount=type=" + m.Type
+	if m.ID != "" {
+		flag += ",id=" + m.ID
+	}
+	return flag + ",target=" + m.Target
+}
+
+// IsBeforeRequirements returns whether the command runs before Python packages are installed
+func (r RunItem) IsBeforeRequirements() bool {
+	return r.Stage == RunStageBeforeRequirements
+}
+
+func (r RunItem) validate() error {
+	if strings.TrimSpace(r.Command) == "" {
+		return fmt.Errorf("run in cog.yaml has an item without a command")
+	}
+	switch r.Stage {
+	case "", RunStageBeforeRequirements, RunStageAfterRequirements:
+	default:
+		return fmt.Errorf("run in cog.yaml: stage must be %s or %s, not %q", RunStageBeforeRequirements, RunStageAfterRequirements, r.Stage)
+	}
+	if r.Workdir != "" && !path.IsAbs(r.Workdir) {
+		return fmt.Errorf("run in cog.yaml: workdir must be an absolute path, not %q", r.Workdir)
+	}
+	for name := range r.Env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("run in cog.yaml: %q isn't a valid environment variable name", name)
+		}
+	}
+	for _, mount := range r.Mounts {
+		switch mount.Type {
+		case RunMountSecret:
+			if mount.ID == "" {
+				return fmt.Errorf("run in cog.yaml: secret mounts need an id")
+			}
+		case RunMountCache:
+		default:
+			return fmt.Errorf("run in cog.yaml: mounts must be of type %s or %s, not %q", RunMountSecret, RunMountCache, mount.Type)
+		}
+		if !path.IsAbs(mount.Target) {
+			return fmt.Errorf("run in cog.yaml: the target of a %s mount must be an absolute path, not %q", mount.Type, mount.Target)
+		}
+		if strings.ContainsAny(mount.ID+mount.Target, ", \n") {
+			return fmt.Errorf("run in cog.yaml: the id and target of mounts can't contain commas, spaces or new lines")
+		}
+	}
+	return nil
+}
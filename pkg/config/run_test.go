@@ -0,0 +1,62 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunItemYAML(t *testing.T) {
+	config, err := FromYAML([]byte(`
+build:
+  run:
+    - |
+      echo one
+      echo two
+    - command: make install
+      workdir: /opt/tool
+      env:
+        PREFIX: /usr/local
+      mounts:
+        - type: cache
+          target: /root/.cache/tool
+      stage: before_requirements
+`))
+	require.NoError(t, err)
+	require.Equal(t, []RunItem{
+		{Command: "echo one\necho two\n"},
+		{
+			Command: "make install",
+			Workdir: "/opt/tool",
+			Env:     map[string]string{"PREFIX": "/usr/local"},
+			Mounts:  []RunMount{{Type: RunMountCache, Target: "/root/.cache/tool"}},
+			Stage:   RunStageBeforeRequirements,
+		},
+	}, config.Build.Run)
+	require.True(t, config.Build.Run[1].IsBeforeRequirements())
+	require.Equal(t, "--mount=type=cache,target=/root/.cache/tool", config.Build.Run[1].Mounts[0].Flag())
+}
+
+func TestValidateRunItem(t *testing.T) {
+	for _, tt := range []struct {
+		run RunItem
+		err string
+	}{
+		{run: RunItem{Command: "echo hello", Stage: RunStageAfterRequirements}},
+		{run: RunItem{Command: "cat /run/secrets/token", Mounts: []RunMount{{Type: RunMountSecret, ID: "token", Target: "/run/secrets/token"}}}},
+		{run: RunItem{Command: " "}, err: "without a command"},
+		{run: RunItem{Command: "echo hello", Stage: "before_system_packages"}, err: "stage must be before_requirements or after_requirements"},
+		{run: RunItem{Command: "echo hello", Workdir: "src"}, err: "workdir must be an absolute path"},
+		{run: RunItem{Command: "echo hello", Env: map[string]string{"MY-VAR": "1"}}, err: "isn't a valid environment variable name"},
+		{run: RunItem{Command: "echo hello", Mounts: []RunMount{{Type: "bind", Target: "/mnt"}}}, err: "mounts must be of type secret or cache"},
+		{run: RunItem{Command: "echo hello", Mounts: []RunMount{{Type: RunMountSecret, Target: "/run/secrets/token"}}}, err: "secret mounts need an id"},
+		{run: RunItem{Command: "echo hello", Mounts: []RunMount{{Type: RunMountCache, Target: "cache"}}}, err: "must be an absolute path"},
+	} {
+		err := tt.run.validate()
+		if tt.err == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, tt.err)
+		}
+	}
+}
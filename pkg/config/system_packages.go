@@ -0,0 +1,173 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	systemPackageNamePattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9+._-]*$`)
+	systemPackageVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+:~_-]*$`)
+	ppaPattern                  = regexp.MustCompile(`^ppa:[a-z0-9][a-z0-9.+-]*/[a-z0-9][a-z0-9.+-]*$`)
+)
+
+// SystemPackage is an entry of build.system_packages. It can be a name, optionally with a version like
+// `ffmpeg=7:6.1.1-1`, or a map that can also add the APT repository the package comes from, mark it as only
+// needed to build, or install a .deb file in the project instead.
+type SystemPackage struct {
+	Name    string `json:"name,omitempty" yaml:"name,omitempty"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Repository is a PPA, like ppa:savoury1/ffmpeg6, or a sources.list line, like
+	// "deb https://example.com/apt stable main"
+	Repository string `json:"repository,omitempty" yaml:"repository,omitempty"`
+	// Key is the URL of the key a sources.list repository is signed with
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// BuildOnly packages are only installed in the builder stage of slim images
+	BuildOnly bool `json:"build_only,omitempty" yaml:"build_only,omitempty"`
+	// Deb is the path of a .deb file in the project to install
+	Deb string `json:"deb,omitempty" yaml:"deb,omitempty"`
+}
+
+// systemPackageFields is SystemPackage without its methods, so it can be unmarshaled without recursing
+type systemPackageFields SystemPackage
+
+func parseSystemPackage(s string) SystemPackage {
+	name, version, _ := strings.Cut(strings.TrimSpace(s), "=")
+	return SystemPackage{Name: name, Version: version}
+}
+
+func (p *SystemPackage) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*p = parseSystemPackage(s)
+		return nil
+	}
+	var fields systemPackageFields
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+	*p = SystemPackage(fields)
+	return nil
+}
+
+func (p SystemPackage) MarshalYAML() (interface{}, error) {
+	if p.isPlain() {
+		return p.String(), nil
+	}
+	return systemPackageFields(p), nil
+}
+
+func (p *SystemPackage) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*p = parseSystemPackage(s)
+		return nil
+	}
+	var fields systemPackageFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*p = SystemPackage(fields)
+	return nil
+}
+
+func (p SystemPackage) MarshalJSON() ([]byte, error) {
+	if p.isPlain() {
+		return json.Marshal(p.String())
+	}
+	return json.Marshal(systemPackageFields(p))
+}
+
+// isPlain returns whether the package can be written as a string
+func (p SystemPackage) isPlain() bool {
+	return p.Repository == "" && p.Key == "" && !p.BuildOnly && p.Deb == ""
+}
+
+// String returns the package as it is given to apt-get install, like ffmpeg=7:6.1.1-1, or the path of the .deb
+func (p SystemPackage) String() string {
+	if p.Deb != "" {
+		return p.Deb
+	}
+	if p.Version != "" {
+		return p.Name + "=" + p.Version
+	}
+	return p.Name
+}
+
+// IsPPA returns whether the repository is a Launchpad PPA, which are only available on Ubuntu
+func (p SystemPackage) IsPPA() bool {
+	return strings.HasPrefix(p.Repository, "ppa:")
+}
+
+func (p SystemPackage) validate(projectDir string) error {
+	if p.Deb != "" {
+		if p.Name != "" || p.Version != "" || p.Repository != "" || p.Key != "" {
+			return fmt.Errorf("system_packages in cog.yaml: deb %s can't be set with a name, version, repository or key", p.Deb)
+		}
+		if !filepath.IsLocal(p.Deb) || !strings.HasSuffix(p.Deb, ".deb") {
+			return fmt.Errorf("system_packages in cog.yaml: deb must be the path of a .deb file in your project, not %q", p.Deb)
+		}
+		if _, err := os.Stat(filepath.Join(projectDir, p.Deb)); err != nil {
+			return fmt.Errorf("Failed to read %s in system_packages: %w", p.Deb, err)
+		}
+		return nil
+	}
+
+	if !systemPackageNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("system_packages in cog.yaml: %q isn't a valid package name. Names can only contain letters, digits and the characters + . _ -", p.Name)
+	}
+	if p.Version != "" && !systemPackageVersionPattern.MatchString(p.Version) {
+		return fmt.Errorf("system_packages in cog.yaml: %q isn't a valid version of %s", p.Version, p.Name)
+	}
+	switch {
+	case p.Repository == "":
+		if p.Key != "" {
+			return fmt.Errorf("system_packages in cog.yaml: %s has a key but no repository", p.Name)
+		}
+	case p.IsPPA():
+		if !ppaPattern.MatchString(p.Repository) {
+			return fmt.Errorf("system_packages in cog.yaml: repository of %s must be like ppa:owner/name, not %q", p.Name, p.Repository)
+		}
+		if p.Key != "" {
+			return fmt.Errorf("system_packages in cog.yaml: the key of PPA %s is added by add-apt-repository, so it can't be set", p.Repository)
+		}
+	default:
+		if fields := strings.Fields(p.Repository); len(fields) < 3 || fields[0] != "deb" || strings.ContainsAny(p.Repository, "\n'") {
+			return fmt.Errorf("system_packages in cog.yaml: repository of %s must be a PPA or a sources.list line like \"deb https://example.com/apt stable main\", not %q", p.Name, p.Repository)
+		}
+		if p.Key != "" {
+			if u, err := url.Parse(p.Key); err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("system_packages in cog.yaml: key of %s must be an https URL, not %q", p.Name, p.Key)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSystemPackages checks build.system_packages before the build starts
+func (c *Config) validateSystemPackages(projectDir string) error {
+	for _, p := range c.Build.SystemPackages {
+		if err := p.validate(projectDir); err != nil {
+			return err
+		}
+		if p.IsPPA() && !c.usesUbuntu() {
+			return fmt.Errorf("system_packages in cog.yaml: PPA %s only works on Ubuntu images, like the CUDA images GPU models are built on. Use a sources.list line for %s instead", p.Repository, p.Name)
+		}
+	}
+	return nil
+}
+
+// usesUbuntu returns whether the image is built on Ubuntu, which GPU models' CUDA images are. CPU models are
+// built on python:<version>, which is Debian, unless base_image is an Ubuntu image.
+func (c *Config) usesUbuntu() bool {
+	if c.Build.GPU {
+		return true
+	}
+	name, _, _ := strings.Cut(c.Build.BaseImage, ":")
+	return name == "ubuntu" || strings.HasSuffix(name, "/ubuntu")
+}
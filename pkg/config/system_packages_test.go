@@ -0,0 +1,85 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSystemPackagesYAML(t *testing.T) {
+	config, err := FromYAML([]byte(`
+build:
+  system_packages:
+    - "ffmpeg=7:6.1.1-1"
+    - name: "libavcodec-dev"
+      build_only: true
+    - name: "nvtop"
+      repository: "deb https://example.com/apt stable main"
+      key: "https://example.com/apt/key.gpg"
+    - deb: "vendor/tool.deb"
+`))
+	require.NoError(t, err)
+	require.Equal(t, []SystemPackage{
+		{Name: "ffmpeg", Version: "7:6.1.1-1"},
+		{Name: "libavcodec-dev", BuildOnly: true},
+		{Name: "nvtop", Repository: "deb https://example.com/apt stable main", Key: "https://example.com/apt/key.gpg"},
+		{Deb: "vendor/tool.deb"},
+	}, config.Build.SystemPackages)
+
+	out, err := yaml.Marshal(config.Build.SystemPackages[:2])
+	require.NoError(t, err)
+	require.Equal(t, "- ffmpeg=7:6.1.1-1\n- name: libavcodec-dev\n  build_only: true\n", string(out))
+
+	data, err := json.Marshal(config.Build.SystemPackages[:2])
+	require.NoError(t, err)
+	require.JSONEq(t, `["ffmpeg=7:6.1.1-1", {"name": "libavcodec-dev", "build_only": true}]`, string(data))
+}
+
+func TestValidateSystemPackagesPPA(t *testing.T) {
+	ppa := []SystemPackage{{Name: "ffmpeg", Repository: "ppa:savoury1/ffmpeg6"}}
+
+	config := &Config{Build: &Build{SystemPackages: ppa}}
+	require.ErrorContains(t, config.validateSystemPackages(""), "PPA ppa:savoury1/ffmpeg6 only works on Ubuntu images")
+
+	config = &Config{Build: &Build{GPU: true, SystemPackages: ppa}}
+	require.NoError(t, config.validateSystemPackages(""))
+
+	config = &Config{Build: &Build{BaseImage: "ubuntu:22.04", SystemPackages: ppa}}
+	require.NoError(t, config.validateSystemPackages(""))
+}
+
+func TestValidateSystemPackages(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "vendor"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vendor/tool.deb"), []byte{}, 0o644))
+
+	for _, tt := range []struct {
+		pkg SystemPackage
+		err string
+	}{
+		{pkg: SystemPackage{Name: "ffmpeg", Version: "7:6.1.1-1"}},
+		{pkg: SystemPackage{Name: "ffmpeg", Repository: "ppa:savoury1/ffmpeg6"}},
+		{pkg: SystemPackage{Name: "nvtop", Repository: "deb [arch=amd64] https://example.com/apt stable main", Key: "https://example.com/key.gpg"}},
+		{pkg: SystemPackage{Deb: "vendor/tool.deb"}},
+		{pkg: SystemPackage{Name: "ffmpeg; rm -rf /"}, err: "isn't a valid package name"},
+		{pkg: SystemPackage{Name: "ffmpeg", Version: "1 2"}, err: "isn't a valid version of ffmpeg"},
+		{pkg: SystemPackage{Name: "ffmpeg", Key: "https://example.com/key.gpg"}, err: "has a key but no repository"},
+		{pkg: SystemPackage{Name: "ffmpeg", Repository: "https://example.com/apt"}, err: "must be a PPA or a sources.list line"},
+		{pkg: SystemPackage{Name: "ffmpeg", Repository: "ppa:savoury1/ffmpeg6", Key: "https://example.com/key.gpg"}, err: "is added by add-apt-repository"},
+		{pkg: SystemPackage{Name: "nvtop", Repository: "deb https://example.com/apt stable main", Key: "http://example.com/key.gpg"}, err: "must be an https URL"},
+		{pkg: SystemPackage{Deb: "../tool.deb"}, err: "must be the path of a .deb file in your project"},
+		{pkg: SystemPackage{Deb: "vendor/missing.deb"}, err: "Failed to read vendor/missing.deb"},
+		{pkg: SystemPackage{Name: "tool", Deb: "vendor/tool.deb"}, err: "can't be set with a name"},
+	} {
+		err := tt.pkg.validate(dir)
+		if tt.err == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, tt.err)
+		}
+	}
+}
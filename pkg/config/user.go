@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Defaults for build.user
+const (
+	DefaultUserName = "cog"
+	DefaultUserID   = 1000
+)
+
+var userNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// User is the user the image runs as, set with build.user. Everything is installed as root, and the user is
+// created after the installs, so it can't change what is installed. It can be set to a name, like `user: cog`,
+// or to a map with a name, uid and gid.
+type User struct {
+	Name string `json:"name,omitempty" yaml:"name"`
+	UID  int    `json:"uid,omitempty" yaml:"uid"`
+	GID  int    `json:"gid,omitempty" yaml:"gid"`
+}
+
+func (u *User) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*u = User{Name: name}
+		return nil
+	}
+	aux := struct {
+		Name string `yaml:"name"`
+		UID  int    `yaml:"uid"`
+		GID  int    `yaml:"gid"`
+	}{}
+	if err := unmarshal(&aux); err != nil {
+		return err
+	}
+	*u = User(aux)
+	return nil
+}
+
+func (u *User) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*u = User{Name: name}
+		return nil
+	}
+	aux := struct {
+		Name string `json:"name"`
+		UID  int    `json:"uid"`
+		GID  int    `json:"gid"`
+	}{}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*u = User(aux)
+	return nil
+}
+
+// complete fills in the defaults of a user. The group has the same ID as the user unless it is set.
+func (u *User) complete() {
+	if u.Name == "" {
+		u.Name = DefaultUserName
+	}
+	if u.UID == 0 {
+		u.UID = DefaultUserID
+	}
+	if u.GID == 0 {
+		u.GID = u.UID
+	}
+}
+
+func (u *User) validate() error {
+	if u.Name == "root" {
+		return fmt.Errorf("user in cog.yaml can't be root. Leave it out to run the image as root")
+	}
+	if !userNamePattern.MatchString(u.Name) {
+		return fmt.Errorf("user.name in cog.yaml must start with a lowercase letter or underscore, and only contain lowercase letters, digits, underscores and dashes, not %q", u.Name)
+	}
+	if u.UID < 0 || u.GID < 0 {
+		return fmt.Errorf("user.uid and user.gid in cog.yaml must be positive")
+	}
+	return nil
+}
+
+// UserSpec returns the user in the format of the USER instruction and docker run --user, uid:gid
+func (u *User) UserSpec() string {
+	return fmt.Sprintf("%d:%d", u.UID, u.GID)
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserFromYAML(t *testing.T) {
+	config, err := FromYAML([]byte(`
+build:
+  python_version: "3.11"
+  user: model
+`))
+	require.NoError(t, err)
+	require.NoError(t, config.ValidateAndComplete(""))
+	require.Equal(t, &User{Name: "model", UID: 1000, GID: 1000}, config.Build.User)
+	require.Equal(t, "1000:1000", config.Build.User.UserSpec())
+
+	config, err = FromYAML([]byte(`
+build:
+  python_version: "3.11"
+  user:
+    uid: 1001
+`))
+	require.NoError(t, err)
+	require.NoError(t, config.ValidateAndComplete(""))
+	require.Equal(t, &User{Name: "cog", UID: 1001, GID: 1001}, config.Build.User)
+}
+
+func TestUserFromJSON(t *testing.T) {
+	user := &User{}
+	require.NoError(t, user.UnmarshalJSON([]byte(`"model"`)))
+	require.Equal(t, &User{Name: "model"}, user)
+
+	require.NoError(t, user.UnmarshalJSON([]byte(`{"name": "model", "uid": 1001, "gid": 1002}`)))
+	require.Equal(t, &User{Name: "model", UID: 1001, GID: 1002}, user)
+}
+
+func TestValidateUser(t *testing.T) {
+	config := &Config{Build: &Build{PythonVersion: "3.11", User: &User{Name: "root"}}}
+	require.ErrorContains(t, config.ValidateAndComplete(""), "user in cog.yaml can't be root")
+
+	config = &Config{Build: &Build{PythonVersion: "3.11", User: &User{Name: "Model User"}}}
+	require.ErrorContains(t, config.ValidateAndComplete(""), `user.name in cog.yaml must start with a lowercase letter`)
+}
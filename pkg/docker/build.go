@@ -5,32 +5,90 @@ import (
 	"io"
 	"os"
 	"os/exec"
-	"runtime"
+	"sort"
 	"strings"
 
-	"github.com/sieve-data/cog/pkg/util"
+	"github.com/sieve-data/cog/pkg/config"
 	"github.com/sieve-data/cog/pkg/util/console"
 )
 
-func Build(dir, dockerfile, imageUrl string, progressOutput string, writer io.Writer, imagesToPull []string) error {
+// BuildCache is where a build imports its cache from and exports it to, in the format of buildx's --cache-from
+// and --cache-to
+type BuildCache struct {
+	From []string
+	To   []string
+	// NoCache builds without using the cache. The cache is still exported.
+	NoCache bool
+}
+
+// Dockerfile is a Dockerfile to build, and the directories other than the build context it copies files from
+type Dockerfile struct {
+	Contents string
+	// Path is where the Dockerfile has been written, relative to the build context. BuildKit then reads the
+	// ignore file next to it, Path + ".dockerignore", instead of the .dockerignore in the build context. If Path
+	// is empty, Contents is passed on stdin.
+	Path string
+	// Contexts are named build contexts the Dockerfile copies from with COPY --from=<name>, mapped to their
+	// directories, which can be relative to the build context
+	Contexts map[string]string
+}
+
+// Build builds and pushes an image. If more than one platform is given, it is built with buildx and
+// pushed as a manifest list, because multi-platform images can't be loaded into the local image store.
+func Build(dir string, dockerfile Dockerfile, imageUrl string, platforms []string, labels map[string]string, progressOutput string, writer io.Writer, cache BuildCache) error {
 
 	imageLatest := strings.Split(imageUrl, ":")[0] + ":latest"
 
 	var args []string
 
-	cache_from_images := []string{imageLatest}
-	for _, image := range imagesToPull {
-		cache_from_images = append(cache_from_images, image)
+	multiPlatform := len(platforms) > 1
+	if multiPlatform || usesBuildxFeatures(cache) {
+		args = buildxBuildArgs(platforms)
+	} else {
+		args = buildKitBuildArgs(platforms)
+	}
+	file := "-"
+	if dockerfile.Path != "" {
+		file = dockerfile.Path
 	}
-	args = buildKitBuildArgs()
 	args = append(args,
-		"--file", "-",
+		"--file", file,
 		"--tag", imageUrl,
 		"--tag", imageLatest,
 		"--progress", progressOutput,
 	)
-	for _, image := range cache_from_images {
-		args = append(args, "--cache-from", "type=registry,ref="+image)
+	if cache.NoCache {
+		args = append(args, "--no-cache")
+	} else {
+		for _, from := range cache.From {
+			args = append(args, "--cache-from", from)
+		}
+	}
+	for _, to := range cache.To {
+		args = append(args, "--cache-to", to)
+	}
+	if config.BuildSourceEpochTimestamp >= 0 {
+		args = append(args, "--build-arg", fmt.Sprintf("SOURCE_DATE_EPOCH=%d", config.BuildSourceEpochTimestamp))
+	}
+	// Labels are sorted so the image config is the same every time
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// Unlike in Dockerfiles, the value here does not need quoting -- Docker merely
+		// splits on the first '=' in the argument and the rest is the label value.
+		args = append(args, "--label", fmt.Sprintf(`%s=%s`, k, labels[k]))
+	}
+
+	names := make([]string, 0, len(dockerfile.Contexts))
+	for name := range dockerfile.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--build-context", fmt.Sprintf("%s=%s", name, dockerfile.Contexts[name]))
 	}
 
 	args = append(args, ".")
@@ -40,13 +98,19 @@ func Build(dir, dockerfile, imageUrl string, progressOutput string, writer io.Wr
 	cmd.Dir = dir
 	cmd.Stdout = writer // redirect stdout to stderr - build output is all messaging
 	cmd.Stderr = writer
-	cmd.Stdin = strings.NewReader(dockerfile)
+	if dockerfile.Path == "" {
+		cmd.Stdin = strings.NewReader(dockerfile.Contents)
+	}
 
 	console.Debug("$ " + strings.Join(cmd.Args, " "))
 	err := cmd.Run()
 	if err != nil {
 		return err
 	}
+	if multiPlatform {
+		// buildx has already pushed both tags
+		return nil
+	}
 
 	pushCommand := []string{"docker", "push", imageUrl}
 	pushcmd := exec.Command(pushCommand[0], pushCommand[1:]...)
@@ -65,7 +129,7 @@ func Build(dir, dockerfile, imageUrl string, progressOutput string, writer io.Wr
 }
 
 func BuildAndPush(dir, dockerfile, imageUrl string, progressOutput string, writer io.Writer) error {
-	err := Build(dir, dockerfile, imageUrl, progressOutput, writer, []string{})
+	err := Build(dir, Dockerfile{Contents: dockerfile}, imageUrl, nil, nil, progressOutput, writer, BuildCache{})
 	if err != nil {
 		return err
 	}
@@ -75,42 +139,34 @@ func BuildAndPush(dir, dockerfile, imageUrl string, progressOutput string, write
 	return cmd.Run()
 }
 
-func BuildAddLabelsToImage(image string, labels map[string]string) error {
-	dockerfile := "FROM " + image
-	var args []string
-	if util.IsM1Mac(runtime.GOOS, runtime.GOARCH) {
-		args = m1BuildxBuildArgs()
-	} else {
-		args = buildKitBuildArgs()
-	}
+// usesBuildxFeatures returns whether the build needs features of buildx that docker build doesn't have.
+// Named build contexts aren't one of them: docker build has --build-context since Docker 23, when it started
+// building with BuildKit, so they don't need the buildx plugin.
+func usesBuildxFeatures(cache BuildCache) bool {
+	return len(cache.To) > 0 || config.BuildSourceEpochTimestamp >= 0
+}
 
-	args = append(args,
-		"--file", "-",
-		"--tag", image,
-	)
-	for k, v := range labels {
-		// Unlike in Dockerfiles, the value here does not need quoting -- Docker merely
-		// splits on the first '=' in the argument and the rest is the label value.
-		args = append(args, "--label", fmt.Sprintf(`%s=%s`, k, v))
+// buildxBuildArgs returns the arguments to build with buildx. Images for more than one platform are pushed,
+// and images for one platform are loaded into the local image store. When SOURCE_DATE_EPOCH is set, the
+// timestamps of files in the layers are rewritten to it, so the layers are the same in every build.
+func buildxBuildArgs(platforms []string) []string {
+	args := []string{"buildx", "build"}
+	if len(platforms) > 0 {
+		args = append(args, "--platform", strings.Join(platforms, ","))
 	}
-	// We're not using context, but Docker requires we pass a context
-	args = append(args, ".")
-	cmd := exec.Command("docker", args...)
-	cmd.Stdin = strings.NewReader(dockerfile)
-
-	console.Debug("$ " + strings.Join(cmd.Args, " "))
-
-	if combinedOutput, err := cmd.CombinedOutput(); err != nil {
-		console.Info(string(combinedOutput))
-		return err
+	output := "type=docker"
+	if len(platforms) > 1 {
+		output = "type=image,push=true"
 	}
-	return nil
-}
-
-func m1BuildxBuildArgs() []string {
-	return []string{"buildx", "build", "--platform", "linux/amd64"}
+	if config.BuildSourceEpochTimestamp >= 0 {
+		output += ",rewrite-timestamp=true"
+	}
+	return append(args, "--output", output)
 }
 
-func buildKitBuildArgs() []string {
-	return []string{"build", "--platform", "linux/amd64"}
+func buildKitBuildArgs(platforms []string) []string {
+	if len(platforms) == 0 {
+		return []string{"build"}
+	}
+	return []string{"build", "--platform", platforms[0]}
 }
@@ -0,0 +1,36 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+// ImageDigest returns the digest of an image in its registry. For multi-platform images it is the digest of
+// the manifest list, so it is the same whichever platform is built.
+func ImageDigest(image string) (string, error) {
+	cmd := exec.Command("docker", "buildx", "imagetools", "inspect", image, "--format", "{{json .Manifest}}")
+	cmd.Env = os.Environ()
+	console.Debug("$ " + strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+	var manifest struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal(out, &manifest); err != nil {
+		return "", fmt.Errorf("Failed to parse manifest of %s: %w", image, err)
+	}
+	if manifest.Digest == "" {
+		return "", fmt.Errorf("The registry didn't return a digest for %s", image)
+	}
+	return manifest.Digest, nil
+}
@@ -27,11 +27,13 @@ type Volume struct {
 }
 
 type RunOptions struct {
-	Args    []string
-	Env     []string
-	GPUs    string
-	Image   string
-	Ports   []Port
+	Args  []string
+	Env   []string
+	GPUs  string
+	Image string
+	Ports []Port
+	// User overrides the user the image runs as, in the form uid[:gid] or name[:group]
+	User    string
 	Volumes []Volume
 	Workdir string
 }
@@ -73,6 +75,9 @@ func generateDockerArgs(options internalRunOptions) []string {
 	if options.TTY {
 		dockerArgs = append(dockerArgs, "--tty")
 	}
+	if options.User != "" {
+		dockerArgs = append(dockerArgs, "--user", options.User)
+	}
 	for _, volume := range options.Volumes {
 		// This needs escaping if we want to support commas in filenames
 		// https://github.com/moby/moby/issues/8604
@@ -0,0 +1,116 @@
+package dockerfile
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Versions of the tools Cog downloads while building. If you change these, change the checksums too.
+const (
+	tiniVersion  = "v0.19.0"
+	pyenvVersion = "2.6.8"
+	// pyenvCommit is the commit pyenvVersion is tagged at, so the archive can't change if the tag is moved
+	pyenvCommit = "519ce9dbf0d1ce810050cfab1ae5a7c40df9fb34"
+	// pyenvSHA256 is the checksum of GitHub's archive of pyenvCommit
+	pyenvSHA256 = "b0cb3fde6bc92dbeb81771bc68d07832dc5a3551fdd2e5b5949757d89302b206"
+	uvVersion   = "0.4.18"
+	// pythonStandaloneRelease is the python-build-standalone release that build.python_install: standalone uses
+	pythonStandaloneRelease = "20240814"
+)
+
+// pythonStandaloneVersions are the Python versions in pythonStandaloneRelease
+var pythonStandaloneVersions = map[string]string{
+	"3.8":  "3.8.19",
+	"3.9":  "3.9.19",
+	"3.10": "3.10.14",
+	"3.11": "3.11.9",
+	"3.12": "3.12.5",
+}
+
+// pythonStandaloneTriples are the python-build-standalone target triples for each architecture
+var pythonStandaloneTriples = map[string]string{
+	"amd64": "x86_64-unknown-linux-gnu",
+	"arm64": "aarch64-unknown-linux-gnu",
+}
+
+// tiniSHA256 are the checksums of the tini binaries for each architecture, from the tini release
+var tiniSHA256 = map[string]string{
+	"amd64": "93dcc18adc78c65a028a84799ecf8ad40c936fdfc5f2a57b1acda5a8117fa82c",
+	"arm64": "07952557df20bfd2a95f9bef198b445e006171969499a1d361bd9e6f8e5e0e81",
+}
+
+// Paths of artifacts in build.artifacts.mirror and build.artifacts.dir
+var (
+	tiniArtifactDir             = "tini/" + tiniVersion
+	pyenvArtifactPath           = "pyenv/pyenv-" + pyenvVersion + ".tar.gz"
+	pythonArtifactsDir          = "python"
+	pythonStandaloneArtifactDir = "python-build-standalone/" + pythonStandaloneRelease
+)
+
+// Upstream URLs of artifacts, used when build.artifacts isn't set
+var (
+	upstreamTiniURL             = "https://github.com/krallin/tini/releases/download/" + tiniVersion
+	upstreamPyenvURL            = "https://github.com/pyenv/pyenv/archive/" + pyenvCommit + ".tar.gz"
+	upstreamPythonStandaloneURL = "https://github.com/indygreg/python-build-standalone/releases/download/" + pythonStandaloneRelease
+)
+
+// UVImage is the image uv is copied from into images, and run from by cog lock
+const UVImage = "ghcr.io/astral-sh/uv:" + uvVersion
+
+// pythonStandaloneFilename returns the name of the python-build-standalone tarball for a Python version and
+// shell expression for the target triple
+func pythonStandaloneFilename(pythonVersion string, triple string) string {
+	return fmt.Sprintf("cpython-%s+%s-%s-install_only.tar.gz", pythonVersion, pythonStandaloneRelease, triple)
+}
+
+// artifactMirror returns the URL of build.artifacts.mirror without a trailing slash, or an empty string
+func (g *Generator) artifactMirror() string {
+	if g.Config.Build.Artifacts == nil {
+		return ""
+	}
+	return strings.TrimSuffix(g.Config.Build.Artifacts.Mirror, "/")
+}
+
+// artifactDir returns build.artifacts.dir, or an empty string
+func (g *Generator) artifactDir() string {
+	if g.Config.Build.Artifacts == nil {
+		return ""
+	}
+	return g.Config.Build.Artifacts.Dir
+}
+
+// localArtifact returns the path of an artifact in build.artifacts.dir relative to the build context,
+// and an error that says where to download it from if it doesn't exist
+func (g *Generator) localArtifact(artifactPath string, upstreamURL string) (string, error) {
+	relPath := path.Join(filepath.ToSlash(g.artifactDir()), artifactPath)
+	if _, err := os.Stat(filepath.Join(g.Dir, filepath.FromSlash(relPath))); err != nil {
+		return "", fmt.Errorf("%s is missing from artifacts.dir in cog.yaml. Download it from %s", relPath, upstreamURL)
+	}
+	return relPath, nil
+}
+
+// tiniChecksumScript returns shell that sets TINI_SHA256 to the checksum of tini for TINI_ARCH
+func tiniChecksumScript() string {
+	return archCaseScript("TINI_ARCH", "TINI_SHA256", tiniSHA256, "tini")
+}
+
+// archCaseScript returns shell that sets setVar to the value for the Debian architecture in archVar,
+// and fails if there isn't one
+func archCaseScript(archVar string, setVar string, values map[string]string, what string) string {
+	archs := []string{}
+	for arch := range values {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	lines := []string{fmt.Sprintf(`case "${%s}" in \`, archVar)}
+	for _, arch := range archs {
+		lines = append(lines, fmt.Sprintf(`%s) %s=%s;; \`, arch, setVar, values[arch]))
+	}
+	lines = append(lines, fmt.Sprintf(`*) echo "%s isn't available for ${%s}"; exit 1;; \`, what, archVar), `esac; \`)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,86 @@
+package dockerfile
+
+import (
+	"strings"
+)
+
+// distro is the family of Linux distributions a base image is built from, which decides how packages are installed
+type distro string
+
+const (
+	distroDebian distro = "debian"
+	distroAlpine distro = "alpine"
+	distroRHEL   distro = "rhel"
+)
+
+// rhelImageNames are the names of images based on RHEL, or distributions that use dnf like it
+var rhelImageNames = []string{"almalinux", "amazonlinux", "centos", "fedora", "oraclelinux", "rhel", "rockylinux", "ubi"}
+
+// detectDistro guesses the distro of an image from the words in its repository and tag, because the image isn't
+// pulled when the Dockerfile is generated. Images that don't name a distro, like python:3.11 and python:3.11-slim,
+// are Debian.
+func detectDistro(image string) distro {
+	name := strings.ToLower(image)
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	// The registry isn't part of the name, so a mirror like alpine.example.com/python:3.11 is still Debian
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		name = parts[1]
+	}
+
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9')
+	})
+	for _, word := range words {
+		// Versions can be part of the word, like alpine3.19 and ubi9
+		word = strings.TrimRight(word, "0123456789")
+		if word == "alpine" {
+			return distroAlpine
+		}
+		for _, rhel := range rhelImageNames {
+			if word == rhel {
+				return distroRHEL
+			}
+		}
+	}
+	return distroDebian
+}
+
+// installPackages returns a RUN instruction that installs system packages
+func (d distro) installPackages(packages []string) string {
+	switch d {
+	case distroAlpine:
+		return "RUN apk add --no-cache " + strings.Join(packages, " ")
+	case distroRHEL:
+		return "RUN --mount=type=cache,target=/var/cache/dnf dnf install -y --setopt=install_weak_deps=False " +
+			strings.Join(packages, " ")
+	default:
+		return "RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get install -qqy " +
+			strings.Join(packages, " ") +
+			" && rm -rf /var/lib/apt/lists/*"
+	}
+}
+
+// archCommand returns a shell command that prints the Debian name of the image's architecture, like amd64, which
+// artifacts are named by
+func (d distro) archCommand() string {
+	if d == distroDebian {
+		return "dpkg --print-architecture"
+	}
+	return "uname -m | sed -e 's/x86_64/amd64/' -e 's/aarch64/arm64/'"
+}
+
+// installCurl returns shell that installs curl to download artifacts with, if the image doesn't have it
+func (d distro) installCurl() string {
+	switch d {
+	case distroAlpine:
+		return `command -v curl >/dev/null || apk add --no-cache curl; \`
+	case distroRHEL:
+		return `command -v curl >/dev/null || dnf install -y curl; \`
+	default:
+		return `apt-get update -qq; \
+apt-get install -qqy --no-install-recommends curl; \
+rm -rf /var/lib/apt/lists/*; \`
+	}
+}
@@ -0,0 +1,105 @@
+package dockerfile
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/util/files"
+)
+
+// ExportContext copies the build context to dir, with the Dockerfile and the temporary files it uses, so the
+// image can be built with docker build after the generator has been cleaned up. It must be called after
+// Generate. The project's .git directory and Cog's other files in .cog aren't copied. It returns the command
+// that builds the image, which passes the temporary files as a named build context if the Dockerfile copies
+// them from one.
+func (g *Generator) ExportContext(dir string, dockerfile string) (string, error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return "", fmt.Errorf("%s already exists and isn't empty", dir)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absProject, err := filepath.Abs(g.Dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(absDir, 0o755); err != nil {
+		return "", fmt.Errorf("Failed to create %s: %w", dir, err)
+	}
+
+	err = filepath.WalkDir(absProject, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(absProject, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if entry.IsDir() && (path == absDir || !g.inContext(rel)) {
+			return filepath.SkipDir
+		}
+		if !g.inContext(rel) {
+			return nil
+		}
+		return copyContextEntry(path, filepath.Join(absDir, rel), entry)
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to copy the build context to %s: %w", dir, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(absDir, "Dockerfile"), []byte(dockerfile+"\n"), 0o644); err != nil {
+		return "", fmt.Errorf("Failed to write the Dockerfile to %s: %w", dir, err)
+	}
+
+	command := "docker build"
+	for name, contextDir := range g.BuildContexts() {
+		command += fmt.Sprintf(" --build-context %s=%s", name, filepath.Join(dir, contextDir))
+	}
+	return command + " " + dir, nil
+}
+
+// inContext returns whether a path relative to the project is copied by ExportContext. In .cog, only the
+// generator's temporary directory and the directories above it are.
+func (g *Generator) inContext(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if rel == ".git" || strings.HasPrefix(rel, ".git/") {
+		return false
+	}
+	if rel != ".cog" && !strings.HasPrefix(rel, ".cog/") {
+		return true
+	}
+	tmp := filepath.ToSlash(g.relativeTmpDir)
+	return rel == tmp || strings.HasPrefix(rel, tmp+"/") || strings.HasPrefix(tmp, rel+"/")
+}
+
+func copyContextEntry(src string, dest string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+	switch {
+	case entry.IsDir():
+		return os.MkdirAll(dest, info.Mode().Perm())
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	case info.Mode().IsRegular():
+		if err := files.CopyFile(src, dest); err != nil {
+			return err
+		}
+		return os.Chmod(dest, info.Mode().Perm())
+	default:
+		// Sockets and other special files can't be sent to Docker either
+		return nil
+	}
+}
@@ -0,0 +1,146 @@
+package dockerfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/config"
+)
+
+// checkSievedataScript fails the build if sievedata installed versions of packages that cog can't use
+const checkSievedataScript = `import sys
+from importlib.metadata import PackageNotFoundError, requires, version
+
+from pip._vendor.packaging.requirements import Requirement
+
+errors = []
+for line in requires("cog") or []:
+    req = Requirement(line)
+    if req.marker is not None and not req.marker.evaluate({"extra": ""}):
+        continue
+    try:
+        installed = version(req.name)
+    except PackageNotFoundError:
+        continue
+    if not req.specifier.contains(installed, prereleases=True):
+        errors.append(f"  cog {version('cog')} needs {req}, but {req.name} {installed} is installed")
+
+if errors:
+    sys.exit(
+        f"sievedata {version('sievedata')} isn't compatible with cog {version('cog')}:\n"
+        + "\n".join(errors)
+        + "\nPin sievedata in cog.yaml to a version that is compatible, or set it to false to not install it."
+    )
+`
+
+func (g *Generator) installCython() (string, error) {
+	req, err := g.Config.CythonRequirement()
+	if err != nil || req == nil {
+		return "", err
+	}
+	if err := g.pinExtra(req); err != nil {
+		return "", err
+	}
+	g.extraRequirements[config.ExtraCython] = req.String()
+	return g.pipInstall(shellQuote(req.String())), nil
+}
+
+// installSieve installs sievedata, and checks it is compatible with the cog wheel. If it isn't pinned in
+// cog.yaml, it is pinned to the latest version on PyPI, which changes over time, so reproducible builds need
+// it pinned.
+func (g *Generator) installSieve() (string, error) {
+	req, err := g.Config.SievedataRequirement()
+	if err != nil || req == nil {
+		return "", err
+	}
+	if config.BuildReproducible && req.URL == "" && req.Version() == "" {
+		return "", fmt.Errorf("sievedata must be pinned to a version for a reproducible build. Pin it with sievedata in cog.yaml, or set it to false to not install it")
+	}
+	if err := g.pinExtra(req); err != nil {
+		return "", err
+	}
+	g.extraRequirements[config.ExtraSievedata] = req.String()
+
+	check, err := g.checkSievedata()
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append([]string{g.pipInstall(shellQuote(req.String()))}, check...), "\n"), nil
+}
+
+// pinExtra pins a package Cog installs besides the model's requirements to the greatest version on PyPI that
+// satisfies its requirement and supports the model's Python version, so the version that is recorded is the
+// version that is installed. Requirements for URLs and exact versions are left as they are.
+func (g *Generator) pinExtra(req *config.Requirement) error {
+	if req.URL != "" || req.Version() != "" {
+		return nil
+	}
+	python := g.Config.Build.PythonVersion
+	versions, ok := g.resolvedVersions[req.Name]
+	if !ok {
+		var err error
+		versions, err = g.resolveVersions(req.Name, python)
+		if err != nil {
+			return fmt.Errorf("Failed to look up the versions of %s on PyPI: %w", req.Name, err)
+		}
+		g.resolvedVersions[req.Name] = versions
+	}
+	version := req.BestMatch(versions)
+	if version == "" {
+		return fmt.Errorf("No version of %s on PyPI that supports Python %s satisfies %s", req.Name, python, req.String())
+	}
+	req.Pin(version)
+	return nil
+}
+
+// checkSievedata returns instructions that check the packages sievedata installed satisfy cog's requirements
+func (g *Generator) checkSievedata() ([]string, error) {
+	lines, containerPath, err := g.writeTemp("check_sievedata.py", []byte(checkSievedataScript))
+	if err != nil {
+		return nil, err
+	}
+	return append(lines, fmt.Sprintf("RUN python %[1]s && rm %[1]s", containerPath)), nil
+}
+
+// ExtraRequirements returns the requirements of the packages Cog installed besides the model's requirements,
+// by package name
+func (g *Generator) ExtraRequirements() map[string]string {
+	return g.extraRequirements
+}
+
+// cogWheelVersion returns the version of the embedded cog wheel, from its METADATA
+func cogWheelVersion() (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(cogWheelEmbed), int64(len(cogWheelEmbed)))
+	if err != nil {
+		return "", fmt.Errorf("Failed to read the cog wheel: %w", err)
+	}
+	for _, file := range reader.File {
+		if !strings.HasSuffix(file.Name, ".dist-info/METADATA") {
+			continue
+		}
+		f, err := file.Open()
+		if err != nil {
+			return "", fmt.Errorf("Failed to read the cog wheel: %w", err)
+		}
+		defer f.Close()
+		// METADATA is in the format of email headers, and fields like License can continue over several lines
+		msg, err := mail.ReadMessage(f)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read the cog wheel's METADATA: %w", err)
+		}
+		version := strings.TrimSpace(msg.Header.Get("Version"))
+		if version == "" {
+			return "", fmt.Errorf("The cog wheel's METADATA doesn't have a version")
+		}
+		return version, nil
+	}
+	return "", fmt.Errorf("The cog wheel doesn't have METADATA")
+}
+
+// shellQuote quotes a string for sh, so requirements with specifiers and markers aren't read as redirects
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
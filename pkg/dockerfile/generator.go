@@ -10,9 +10,12 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/docker"
+	"github.com/sieve-data/cog/pkg/util/console"
 )
 
 //go:embed embed/cog.whl
@@ -30,10 +33,50 @@ type Generator struct {
 	tmpDir string
 	// tmpDir relative to Dir
 	relativeTmpDir string
+	// tmpDirLock is held while tmpDir is in use, so other generators don't remove it. It is nil if tmpDir
+	// isn't a temporary directory made by NewGenerator.
+	tmpDirLock *os.File
+	// tmpBuildContext is the name of the build context the Dockerfile copies the files in tmpDir from. If it is
+	// empty, they are copied from their path in the project.
+	tmpBuildContext string
+
+	// resolveDigest looks up the digest of an image, for pinning images in reproducible builds
+	resolveDigest     func(image string) (string, error)
+	pinnedImages      map[string]string
+	extraRequirements map[string]string
+	// resolveVersions looks up the versions of a package that can be installed on a Python version, for pinning
+	// the packages Cog installs besides the model's requirements
+	resolveVersions  func(name string, python string) ([]string, error)
+	resolvedVersions map[string][]string
 }
 
+// NewGenerator returns a generator that writes the files the Dockerfile copies to a temporary directory in
+// .cog/tmp that only it uses, so more than one build of a project can run at the same time. The directory is
+// removed by Cleanup.
 func NewGenerator(config *config.Config, dir string) (*Generator, error) {
-	rootTmp := path.Join(dir, ".cog/tmp/build")
+	tmpDir, lock, err := makeTmpDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a temporary directory in %s: %w", tmpRoot, err)
+	}
+	relativeTmpDir, err := filepath.Rel(dir, tmpDir)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	g, err := NewGeneratorWithBuildDir(config, dir, relativeTmpDir)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	g.tmpDirLock = lock
+	g.tmpBuildContext = tmpBuildContext
+	return g, nil
+}
+
+// NewGeneratorWithBuildDir returns a generator that writes the files the Dockerfile copies, like requirements
+// and the cog wheel, to buildDir in the project instead of a temporary directory
+func NewGeneratorWithBuildDir(config *config.Config, dir string, buildDir string) (*Generator, error) {
+	rootTmp := path.Join(dir, buildDir)
 	if err := os.MkdirAll(rootTmp, 0o755); err != nil {
 		return nil, err
 	}
@@ -47,9 +90,15 @@ func NewGenerator(config *config.Config, dir string) (*Generator, error) {
 		Config:         config,
 		Dir:            dir,
 		GOOS:           runtime.GOOS,
-		GOARCH:         runtime.GOOS,
+		GOARCH:         runtime.GOARCH,
 		tmpDir:         rootTmp,
 		relativeTmpDir: relativeTmpDir,
+		resolveDigest:  docker.ImageDigest,
+		pinnedImages:   map[string]string{},
+
+		extraRequirements: map[string]string{},
+		resolveVersions:   pypiVersions,
+		resolvedVersions:  map[string][]string{},
 	}, nil
 }
 
@@ -58,6 +107,21 @@ func (g *Generator) GenerateBase() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	images := []string{dockerfileFrontend, baseImage}
+	if g.usesUVImage() {
+		images = append(images, UVImage)
+	}
+	runtimeImage := ""
+	if g.Config.Build.Slim {
+		runtimeImage, err = g.runtimeImage()
+		if err != nil {
+			return "", err
+		}
+		images = append(images, runtimeImage)
+	}
+	if err := g.pinImages(images...); err != nil {
+		return "", err
+	}
 
 	installPython := ""
 	if g.Config.Build.GPU {
@@ -67,7 +131,32 @@ func (g *Generator) GenerateBase() (string, error) {
 		}
 	}
 
-	aptInstalls, err := g.aptInstalls()
+	preamble, err := g.preamble()
+	if err != nil {
+		return "", err
+	}
+
+	installTini, err := g.installTini()
+	if err != nil {
+		return "", err
+	}
+
+	installCog, err := g.installCog()
+	if err != nil {
+		return "", err
+	}
+
+	installCython, err := g.installCython()
+	if err != nil {
+		return "", err
+	}
+
+	installSieve, err := g.installSieve()
+	if err != nil {
+		return "", err
+	}
+
+	systemPackages, err := g.installSystemPackages(true)
 	if err != nil {
 		return "", err
 	}
@@ -82,26 +171,67 @@ func (g *Generator) GenerateBase() (string, error) {
 	// 	return "", err
 	// }
 
-	run, err := g.run()
-	if err != nil {
-		return "", err
+	runBeforeRequirements := g.run(true)
+	run := g.run(false)
+
+	if g.Config.Build.Slim {
+		runtimeSystemPackages, err := g.installSystemPackages(false)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(filterEmpty([]string{
+			"# syntax = " + g.imageRef(dockerfileFrontend),
+			"FROM " + g.imageRef(baseImage) + " AS builder",
+			preamble,
+			installTini,
+			installPython,
+			g.installUV(),
+			installCython,
+			installCog,
+			systemPackages,
+			runBeforeRequirements,
+			pythonRequirements,
+			installSieve,
+			g.findRuntimePackages(),
+			"FROM " + g.imageRef(runtimeImage),
+			preamble,
+			g.copyFromBuilder(),
+			runtimeSystemPackages,
+			// after_requirements commands run in the runtime stage, so what they make is in the final image.
+			// They can't build anything that needs the compilers and headers in the builder stage.
+			run,
+			g.finish(),
+		}), "\n"), nil
 	}
 
 	return strings.Join(filterEmpty([]string{
-		"# syntax = docker/dockerfile:1.2",
-		"FROM " + baseImage,
-		g.preamble(),
-		g.installTini(),
+		"# syntax = " + g.imageRef(dockerfileFrontend),
+		"FROM " + g.imageRef(baseImage),
+		preamble,
+		installTini,
 		installPython,
-		g.installCython(),
-		aptInstalls,
+		g.installUV(),
+		installCython,
+		installCog,
+		systemPackages,
+		runBeforeRequirements,
 		pythonRequirements,
 		run,
-		g.installSieve(),
+		installSieve,
+		g.finish(),
+	}), "\n"), nil
+}
+
+// finish checks the image can serve predictions, and sets how it runs
+func (g *Generator) finish() string {
+	return strings.Join(filterEmpty([]string{
+		g.checkCogRuntime(),
+		g.createUser(),
 		`WORKDIR /src`,
+		g.user(),
 		`EXPOSE 5000`,
 		`CMD ["python", "-m", "cog.server.http"]`,
-	}), "\n"), nil
+	}), "\n")
 }
 
 func (g *Generator) Generate() (string, error) {
@@ -119,9 +249,53 @@ func (g *Generator) Cleanup() error {
 	if err := os.RemoveAll(g.tmpDir); err != nil {
 		return fmt.Errorf("Failed to clean up %s: %w", g.tmpDir, err)
 	}
+	if g.tmpDirLock != nil {
+		// The lock is released after the directory is removed, so other generators don't remove it too
+		defer g.tmpDirLock.Close()
+		if err := os.Remove(g.tmpDirLock.Name()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to clean up %s: %w", g.tmpDirLock.Name(), err)
+		}
+	}
 	return nil
 }
 
+// WriteDockerfile writes the Dockerfile to the generator's temporary directory, with an ignore file that keeps
+// .cog out of the build context as well as the files in the project's .dockerignore. It returns the Dockerfile
+// with the build contexts it copies from, to pass to docker.Build.
+func (g *Generator) WriteDockerfile(contents string) (docker.Dockerfile, error) {
+	dockerignore, err := os.ReadFile(filepath.Join(g.Dir, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return docker.Dockerfile{}, fmt.Errorf("Failed to read .dockerignore: %w", err)
+	}
+	if len(dockerignore) > 0 && !strings.HasSuffix(string(dockerignore), "\n") {
+		dockerignore = append(dockerignore, '\n')
+	}
+	// BuildKit reads this instead of the project's .dockerignore
+	dockerignore = append(dockerignore, "# Cog's own files, like the temporary files of other builds\n.cog\n"...)
+
+	path := filepath.Join(g.tmpDir, "Dockerfile")
+	if err := os.WriteFile(path, []byte(contents+"\n"), 0o644); err != nil {
+		return docker.Dockerfile{}, fmt.Errorf("Failed to write Dockerfile: %w", err)
+	}
+	if err := os.WriteFile(path+".dockerignore", dockerignore, 0o644); err != nil {
+		return docker.Dockerfile{}, fmt.Errorf("Failed to write Dockerfile.dockerignore: %w", err)
+	}
+	return docker.Dockerfile{
+		Contents: contents,
+		Path:     filepath.Join(g.relativeTmpDir, "Dockerfile"),
+		Contexts: g.BuildContexts(),
+	}, nil
+}
+
+// BuildContexts returns the named build contexts the Dockerfile copies from, mapped to their directories relative
+// to the project
+func (g *Generator) BuildContexts() map[string]string {
+	if g.tmpBuildContext == "" {
+		return map[string]string{}
+	}
+	return map[string]string{g.tmpBuildContext: g.relativeTmpDir}
+}
+
 func (g *Generator) baseImage() (string, error) {
 	if g.Config.Build.GPU {
 		if err := g.Config.ValidateAndComplete(g.Dir); err != nil {
@@ -129,53 +303,111 @@ func (g *Generator) baseImage() (string, error) {
 		}
 		return g.Config.CUDABaseImageTag()
 	}
+	if g.Config.Build.BaseImage != "" {
+		return g.Config.Build.BaseImage, nil
+	}
 	return "python:" + g.Config.Build.PythonVersion, nil
 }
 
-func (g *Generator) preamble() string {
+// distro returns the distro of the base image. CUDA images are Ubuntu.
+func (g *Generator) distro() distro {
+	if g.Config.Build.GPU || g.Config.Build.BaseImage == "" {
+		return distroDebian
+	}
+	return detectDistro(g.Config.Build.BaseImage)
+}
+
+func (g *Generator) preamble() (string, error) {
+	// Directories that don't exist are ignored, so multi-platform images can have the library directories of every platform
+	libDirs := []string{"$LD_LIBRARY_PATH"}
+	for _, platform := range g.Config.Platforms() {
+		_, goarch, err := config.ParsePlatform(platform)
+		if err != nil {
+			return "", err
+		}
+		libDirs = append(libDirs, config.PlatformLibDir(goarch))
+	}
+	libDirs = append(libDirs, "/usr/local/nvidia/lib64", "/usr/local/nvidia/bin")
+
 	return `ENV DEBIAN_FRONTEND=noninteractive
 ENV PYTHONUNBUFFERED=1
-ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin
-ENV NVIDIA_DRIVER_CAPABILITIES=all`
+ENV LD_LIBRARY_PATH=` + strings.Join(libDirs, ":") + `
+ENV NVIDIA_DRIVER_CAPABILITIES=all`, nil
 }
 
-func (g *Generator) installTini() string {
+func (g *Generator) installTini() (string, error) {
 	// Install tini as the image entrypoint to provide signal handling and process
 	// reaping appropriate for PID 1.
 	//
 	// N.B. If you remove/change this, consider removing/changing the `has_init`
 	// image label applied in image/build.go.
-	lines := []string{
-		`RUN --mount=type=cache,target=/var/cache/apt set -eux; \
-apt-get update -qq; \
-apt-get install -qqy --no-install-recommends curl; \
-rm -rf /var/lib/apt/lists/*; \
-TINI_VERSION=v0.19.0; \
-TINI_ARCH="$(dpkg --print-architecture)"; \
-curl -sSL -o /sbin/tini "https://github.com/krallin/tini/releases/download/${TINI_VERSION}/tini-${TINI_ARCH}"; \
+	distro := g.distro()
+	if distro == distroAlpine {
+		// The upstream tini binaries are linked against glibc, so Alpine's package is used instead
+		return `RUN apk add --no-cache tini
+ENTRYPOINT ["/sbin/tini", "--"]`, nil
+	}
+	lines := []string{}
+	install := ""
+	if g.artifactDir() != "" {
+		for _, platform := range g.Config.Platforms() {
+			_, goarch, err := config.ParsePlatform(platform)
+			if err != nil {
+				return "", err
+			}
+			tiniPath, err := g.localArtifact(tiniArtifactDir+"/tini-"+goarch, upstreamTiniURL+"/tini-"+goarch)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, fmt.Sprintf("COPY %s /tmp/tini/tini-%s", tiniPath, goarch))
+		}
+		install = `RUN set -eux; \
+TINI_ARCH="$(` + distro.archCommand() + `)"; \
+` + tiniChecksumScript() + `
+mv "/tmp/tini/tini-${TINI_ARCH}" /sbin/tini; \
+rm -rf /tmp/tini; \`
+	} else {
+		tiniURL := upstreamTiniURL
+		if mirror := g.artifactMirror(); mirror != "" {
+			tiniURL = mirror + "/" + tiniArtifactDir
+		}
+		mount := ""
+		if distro == distroDebian {
+			mount = "--mount=type=cache,target=/var/cache/apt "
+		}
+		install = `RUN ` + mount + `set -eux; \
+` + distro.installCurl() + `
+TINI_ARCH="$(` + distro.archCommand() + `)"; \
+` + tiniChecksumScript() + `
+curl -sSL -o /sbin/tini "` + tiniURL + `/tini-${TINI_ARCH}"; \`
+	}
+	lines = append(lines,
+		install+`
+echo "${TINI_SHA256}  /sbin/tini" | sha256sum -c -; \
 chmod +x /sbin/tini`,
 		`ENTRYPOINT ["/sbin/tini", "--"]`,
-	}
-	return strings.Join(lines, "\n")
+	)
+	return strings.Join(lines, "\n"), nil
 }
 
-func (g *Generator) aptInstalls() (string, error) {
-	packages := g.Config.Build.SystemPackages
-	if len(packages) == 0 {
-		return "", nil
+// pythonPathEnv puts the Python installed in GPU images on the PATH
+func (g *Generator) pythonPathEnv() string {
+	switch g.Config.Build.PythonInstall {
+	case config.PythonInstallStandalone, config.PythonInstallUV:
+		// Prebuilt Pythons are installed in /root/.python, and have python and pip in the same place as pyenv's shims
+		return `ENV PATH="/root/.python/bin:$PATH"`
+	default:
+		return `ENV PATH="/root/.pyenv/shims:/root/.pyenv/bin:$PATH"`
 	}
-	return "RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get install -qqy " +
-		strings.Join(packages, " ") +
-		" && rm -rf /var/lib/apt/lists/*", nil
 }
 
 func (g *Generator) installPythonCUDA() (string, error) {
 	// TODO: check that python version is valid
 
-	py := g.Config.Build.PythonVersion
-
-	return `ENV PATH="/root/.pyenv/shims:/root/.pyenv/bin:$PATH"
-RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get install -qqy --no-install-recommends \
+	lines := []string{g.pythonPathEnv()}
+	// These are needed to compile Python with pyenv, but they are installed for prebuilt Pythons too,
+	// because commands in run and packages built from source might need them
+	lines = append(lines, `RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get install -qqy --no-install-recommends \
 	make \
 	build-essential \
 	libssl-dev \
@@ -194,26 +426,204 @@ RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get insta
 	liblzma-dev \
 	git \
 	ca-certificates \
-	&& rm -rf /var/lib/apt/lists/*
-` + fmt.Sprintf(`RUN curl -s -S -L https://raw.githubusercontent.com/pyenv/pyenv-installer/master/bin/pyenv-installer | bash && \
-	git clone https://github.com/momo-lab/pyenv-install-latest.git "$(pyenv root)"/plugins/pyenv-install-latest && \
-	pyenv install-latest "%s" && \
-	pyenv global $(pyenv install-latest --print "%s") && \
-	pip install "wheel<1"`, py, py), nil
+	&& rm -rf /var/lib/apt/lists/*`)
+
+	var install []string
+	var err error
+	switch g.Config.Build.PythonInstall {
+	case config.PythonInstallStandalone:
+		install, err = g.installPythonStandalone()
+	case config.PythonInstallUV:
+		install, err = g.installPythonUV()
+	default:
+		install, err = g.installPythonPyenv()
+	}
+	if err != nil {
+		return "", err
+	}
+	lines = append(lines, install...)
+	return strings.Join(lines, "\n"), nil
+}
+
+// installPythonPyenv compiles Python from source with pyenv
+func (g *Generator) installPythonPyenv() ([]string, error) {
+	py := g.Config.Build.PythonVersion
+	lines := []string{}
+
+	// pyenv is pinned to a commit, and its checksum is checked in case a mirror or artifact directory has the wrong one
+	checkPyenv := fmt.Sprintf(`echo "%s  /tmp/pyenv.tar.gz" | sha256sum -c -`, pyenvSHA256)
+	extractPyenv := `mkdir -p /root/.pyenv && tar -xzf /tmp/pyenv.tar.gz --strip-components=1 -C /root/.pyenv && rm /tmp/pyenv.tar.gz`
+	pythonBuildEnv := ""
+	if g.artifactDir() != "" {
+		pyenvPath, err := g.localArtifact(pyenvArtifactPath, upstreamPyenvURL)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines,
+			fmt.Sprintf("COPY %s /tmp/pyenv.tar.gz", pyenvPath),
+			`RUN `+checkPyenv+` && \
+	`+extractPyenv,
+		)
+		// Python source tarballs are optional, because pyenv can download them
+		if pythonPath, err := g.localArtifact(pythonArtifactsDir, "https://www.python.org/ftp/python/"); err == nil {
+			lines = append(lines, fmt.Sprintf("COPY %s /root/.pyenv/cache", pythonPath))
+			pythonBuildEnv = "PYTHON_BUILD_CACHE_PATH=/root/.pyenv/cache "
+		}
+	} else {
+		pyenvURL := upstreamPyenvURL
+		if mirror := g.artifactMirror(); mirror != "" {
+			pyenvURL = mirror + "/" + pyenvArtifactPath
+			// pyenv falls back to python.org if a Python version isn't on the mirror
+			pythonBuildEnv = fmt.Sprintf("PYTHON_BUILD_MIRROR_URL=%s/%s PYTHON_BUILD_MIRROR_URL_SKIP_CHECKSUM=1 ", mirror, pythonArtifactsDir)
+		}
+		lines = append(lines, fmt.Sprintf(`RUN curl -sSL -o /tmp/pyenv.tar.gz "%s" && \
+	%s && \
+	%s`, pyenvURL, checkPyenv, extractPyenv))
+	}
+
+	lines = append(lines, fmt.Sprintf(`RUN PYTHON_VERSION="$(pyenv latest -k "%s")" && \
+	%spyenv install "$PYTHON_VERSION" && \
+	pyenv global "$PYTHON_VERSION" && \
+	pip install "wheel<1"`, py, pythonBuildEnv))
+	return lines, nil
 }
 
+// installPythonStandalone installs a prebuilt Python from python-build-standalone, checked against the
+// checksums published with the release
+func (g *Generator) installPythonStandalone() ([]string, error) {
+	pythonVersion, err := g.pythonStandaloneVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	run := "RUN --mount=type=cache,target=/root/.cache/python-build-standalone set -eux; \\"
+	fetch := ""
+	cleanup := ""
+	if g.artifactDir() != "" {
+		files := []string{"SHA256SUMS"}
+		for _, platform := range g.Config.Platforms() {
+			_, goarch, err := config.ParsePlatform(platform)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, pythonStandaloneFilename(pythonVersion, pythonStandaloneTriples[goarch]))
+		}
+		for _, filename := range files {
+			localPath, err := g.localArtifact(pythonStandaloneArtifactDir+"/"+filename, upstreamPythonStandaloneURL+"/"+filename)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, fmt.Sprintf("COPY %s /tmp/python-build-standalone/%s", localPath, filename))
+		}
+		run = "RUN set -eux; \\"
+		fetch = "cd /tmp/python-build-standalone; \\"
+		cleanup = "\nrm -rf /tmp/python-build-standalone; \\"
+	} else {
+		baseURL := upstreamPythonStandaloneURL
+		if mirror := g.artifactMirror(); mirror != "" {
+			baseURL = mirror + "/" + pythonStandaloneArtifactDir
+		}
+		fetch = fmt.Sprintf(`cd /root/.cache/python-build-standalone; \
+curl -sSfL -o SHA256SUMS "%[1]s/SHA256SUMS"; \
+if ! grep " ${PYTHON_FILENAME}$" SHA256SUMS | sha256sum -c --status -; then \
+curl -sSfL -o "${PYTHON_FILENAME}" "%[1]s/${PYTHON_FILENAME}"; \
+fi; \`, baseURL)
+	}
+
+	lines = append(lines, run+`
+PYTHON_ARCH="$(dpkg --print-architecture)"; \
+`+archCaseScript("PYTHON_ARCH", "PYTHON_TRIPLE", pythonStandaloneTriples, "python-build-standalone")+`
+PYTHON_FILENAME="`+pythonStandaloneFilename(pythonVersion, "${PYTHON_TRIPLE}")+`"; \
+`+fetch+`
+grep " ${PYTHON_FILENAME}$" SHA256SUMS | sha256sum -c -; \
+mkdir -p /root/.python; \
+tar -xzf "${PYTHON_FILENAME}" --strip-components=1 -C /root/.python; \`+cleanup+`
+ln -sf python3 /root/.python/bin/python; \
+ln -sf pip3 /root/.python/bin/pip; \
+pip install "wheel<1"`)
+	return lines, nil
+}
+
+// pythonStandaloneVersion returns the version of Python in the python-build-standalone release that
+// matches python_version
+func (g *Generator) pythonStandaloneVersion() (string, error) {
+	py := g.Config.Build.PythonVersion
+	minor := py
+	if parts := strings.Split(py, "."); len(parts) > 2 {
+		minor = strings.Join(parts[:2], ".")
+	}
+	pythonVersion, ok := pythonStandaloneVersions[minor]
+	if !ok {
+		return "", fmt.Errorf("python_install: standalone doesn't support Python %s. Use python_install: pyenv instead", py)
+	}
+	if py != minor && py != pythonVersion {
+		return "", fmt.Errorf("python_install: standalone only has Python %s, not %s. Set python_version to %s, or use python_install: pyenv instead", pythonVersion, py, minor)
+	}
+	return pythonVersion, nil
+}
+
+// installPythonUV installs a prebuilt Python with uv
+func (g *Generator) installPythonUV() ([]string, error) {
+	py := g.Config.Build.PythonVersion
+	mirrorEnv := ""
+	if mirror := g.artifactMirror(); mirror != "" {
+		// uv downloads python-build-standalone releases, so mirrors have the same layout as for python_install: standalone
+		mirrorEnv = fmt.Sprintf("export UV_PYTHON_INSTALL_MIRROR=%s/python-build-standalone; \\\n", mirror)
+	}
+
+	return []string{
+		g.copyUV(),
+		fmt.Sprintf(`RUN --mount=type=cache,target=/root/.cache/uv set -eux; \
+%suv python install "%[2]s"; \
+PYTHON_BIN="$(readlink -f "$(uv python find --python-preference only-managed "%[2]s")")"; \
+ln -s "$(dirname "$(dirname "${PYTHON_BIN}")")" /root/.python; \
+rm -f /root/.python/lib/python*/EXTERNALLY-MANAGED; \
+ln -sf python3 /root/.python/bin/python; \
+python -m pip --version || python -m ensurepip --default-pip; \
+pip install "wheel<1"`, mirrorEnv, py),
+	}, nil
+}
+
+// installCog installs the cog Python package that serves predictions, from build.cog_runtime
 func (g *Generator) installCog() (string, error) {
-	// Wheel name needs to be full format otherwise pip refuses to install it
-	cogFilename := "cog-0.0.1.dev-py3-none-any.whl"
-	cogPath := filepath.Join(g.relativeTmpDir, cogFilename)
-	if err := os.MkdirAll(filepath.Dir(cogPath), 0o755); err != nil {
-		return "", fmt.Errorf("Failed to write %s: %w", cogFilename, err)
+	source, err := g.Config.CogRuntimeSource()
+	if err != nil {
+		return "", err
 	}
-	if err := os.WriteFile(cogPath, cogWheelEmbed, 0o644); err != nil {
-		return "", fmt.Errorf("Failed to write %s: %w", cogFilename, err)
+	switch {
+	case source.Requirement != nil:
+		if err := g.pinExtra(source.Requirement); err != nil {
+			return "", err
+		}
+		g.extraRequirements["cog"] = source.Requirement.String()
+		return g.pipInstall(shellQuote(source.Requirement.String())), nil
+	case source.Path != "":
+		g.extraRequirements["cog"] = source.Path
+		containerPath := "/tmp/cog-runtime/" + path.Base(source.Path)
+		return strings.Join([]string{
+			fmt.Sprintf("COPY %s %s", source.Path, containerPath),
+			g.pipInstall(fmt.Sprintf("%[1]s && rm -rf %[1]s", containerPath)),
+		}, "\n"), nil
+	}
+
+	version, err := cogWheelVersion()
+	if err != nil {
+		return "", err
 	}
-	return fmt.Sprintf(`COPY %s /tmp/%s
-RUN --mount=type=cache,target=/root/.cache/pip pip install /tmp/%s`, path.Join(g.relativeTmpDir, cogFilename), cogFilename, cogFilename), nil
+	g.extraRequirements["cog"] = "cog==" + version
+	// Wheel name needs to be full format otherwise pip refuses to install it
+	lines, containerPath, err := g.writeTemp("cog-0.0.1.dev-py3-none-any.whl", cogWheelEmbed)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append(lines, g.pipInstall(fmt.Sprintf("%[1]s && rm %[1]s", containerPath))), "\n"), nil
+}
+
+// checkCogRuntime fails the build if the server can't be imported, which happens if the model's packages
+// aren't compatible with cog
+func (g *Generator) checkCogRuntime() string {
+	return `RUN python -c "import cog.server.http" || (echo "cog.server.http can't be imported, so the image can't serve predictions. Check that your Python packages are compatible with cog." && exit 1)`
 }
 
 func (g *Generator) installPydanticNoBinary() string {
@@ -224,31 +634,200 @@ func (g *Generator) uninstallPydantic() string {
 	return "RUN --mount=type=cache,target=/root/.cache/pip pip uninstall pydantic -y"
 }
 
-func (g *Generator) installCython() string {
-	return "RUN --mount=type=cache,target=/root/.cache/pip pip install cython==\"0.29.34\""
+func (g *Generator) copyUV() string {
+	return fmt.Sprintf("COPY --from=%s /uv /usr/local/bin/uv", g.imageRef(UVImage))
+}
+
+// installUV makes uv available to install packages with build.installer: uv, if it hasn't been already
+func (g *Generator) installUV() string {
+	if !g.Config.UsesUV() || (g.Config.Build.GPU && g.Config.Build.PythonInstall == config.PythonInstallUV) {
+		// uv is copied in when Python is installed
+		return ""
+	}
+	return g.copyUV()
+}
+
+// pipInstall returns a RUN instruction that installs Python packages with build.installer
+func (g *Generator) pipInstall(args string) string {
+	if !g.Config.UsesUV() {
+		return "RUN --mount=type=cache,target=/root/.cache/pip pip install " + args
+	}
+	run := "RUN --mount=type=cache,target=/root/.cache/uv uv pip install --system " + args
+	if g.Config.Build.GPU && (g.Config.Build.PythonInstall == "" || g.Config.Build.PythonInstall == config.PythonInstallPyenv) {
+		// pip makes pyenv shims for the commands packages install, but uv doesn't
+		run += " && pyenv rehash"
+	}
+	return run
+}
+
+// createUser creates build.user after everything has been installed, and gives it /src and its cache directory.
+// Users and groups are created even if their IDs are taken, because some base images already have a user 1000.
+func (g *Generator) createUser() string {
+	user := g.Config.Build.User
+	if user == nil {
+		return ""
+	}
+	home := "/home/" + user.Name
+	chmodRoot := ""
+	if g.Config.Build.GPU {
+		// Python is installed in /root, which only root can enter
+		chmodRoot = " && \\\nchmod o+x /root"
+	}
+	if g.distro() == distroAlpine {
+		// BusyBox's addgroup and adduser can't reuse IDs that are taken
+		return fmt.Sprintf(`RUN addgroup -g %[3]d %[1]s && \
+adduser -D -u %[2]d -G %[1]s -s /bin/sh %[1]s && \
+mkdir -p /src %[4]s/.cache && \
+chown %[2]d:%[3]d /src %[4]s/.cache`, user.Name, user.UID, user.GID, home)
+	}
+	return fmt.Sprintf(`RUN groupadd --non-unique --gid %[3]d %[1]s && \
+useradd --no-log-init --non-unique --uid %[2]d --gid %[3]d --create-home --shell /bin/bash %[1]s && \
+mkdir -p /src %[4]s/.cache && \
+chown %[2]d:%[3]d /src %[4]s/.cache%[5]s`, user.Name, user.UID, user.GID, home, chmodRoot)
 }
-func (g *Generator) installSieve() string {
-	return "RUN --mount=type=cache,target=/root/.cache/pip pip install sievedata"
+
+// user runs the image as build.user. The user is given by ID, so it can be checked to not be root without
+// reading /etc/passwd.
+func (g *Generator) user() string {
+	if g.Config.Build.User == nil {
+		return ""
+	}
+	return "USER " + g.Config.Build.User.UserSpec()
 }
 
+// pythonRequirements installs the requirements resolved for each platform, or the lockfiles made from them by
+// cog lock. If they differ between platforms, the requirements for the platform being built are picked with the
+// TARGETARCH build argument.
 func (g *Generator) pythonRequirements() (string, error) {
-	reqs := g.Config.Build.PythonPackages
+	requirementsByArch, err := g.lockedRequirements()
+	if err != nil {
+		return "", err
+	}
+	if requirementsByArch == nil {
+		if g.Config.UsesUV() && g.hasUVLock() {
+			return g.uvLockRequirements()
+		}
+		requirementsByArch = map[string]string{}
+		for _, platform := range g.Config.Platforms() {
+			goos, goarch, err := config.ParsePlatform(platform)
+			if err != nil {
+				return "", err
+			}
+			requirements, err := g.Config.PythonRequirementsForArch(goos, goarch, []string{})
+			if err != nil {
+				return "", err
+			}
+			requirementsByArch[goarch] = requirements
+		}
+	}
 
-	if len(reqs) == 0 {
-		return "", nil
+	archs := []string{}
+	for _, platform := range g.Config.Platforms() {
+		_, goarch, err := config.ParsePlatform(platform)
+		if err != nil {
+			return "", err
+		}
+		archs = append(archs, goarch)
+	}
+	samePerArch := true
+	for _, arch := range archs {
+		samePerArch = samePerArch && requirementsByArch[arch] == requirementsByArch[archs[0]]
+	}
+	if samePerArch {
+		requirements := requirementsByArch[archs[0]]
+		if strings.TrimSpace(requirements) == "" {
+			return "", nil
+		}
+		lines, containerPath, err := g.writeTemp("requirements.txt", []byte(requirements))
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, g.pipInstall(fmt.Sprintf("-r %[1]s && rm %[1]s", containerPath)))
+		return strings.Join(lines, "\n"), nil
+	}
+
+	lines := []string{}
+	for _, arch := range archs {
+		copyLines, _, err := g.writeTemp("requirements-"+arch+".txt", []byte(requirementsByArch[arch]))
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, copyLines...)
+	}
+	lines = append(lines,
+		"ARG TARGETARCH",
+		g.pipInstall("-r /tmp/requirements-${TARGETARCH}.txt && rm /tmp/requirements-*.txt"),
+	)
+	return strings.Join(lines, "\n"), nil
+}
+
+// lockedRequirements returns the lockfiles written by cog lock for each architecture, or nil if there aren't any.
+// It returns an error if a lockfile is missing or was made from different requirements.
+//
+// Lockfiles that weren't written by cog lock are ignored, because requirements.lock is a common name for other
+// tools' files, unless build.installer is uv, in which case they are installed as they are.
+func (g *Generator) lockedRequirements() (map[string]string, error) {
+	locked := map[string]string{}
+	missing := []string{}
+	for _, platform := range g.Config.Platforms() {
+		goos, goarch, err := config.ParsePlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+		filename := g.Config.RequirementsLockFilenameForArch(goarch)
+		contents, err := os.ReadFile(filepath.Join(g.Dir, filename))
+		if os.IsNotExist(err) {
+			missing = append(missing, filename)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %w", filename, err)
+		}
+		lock := strings.ReplaceAll(string(contents), "\r\n", "\n")
+		if !config.IsCogRequirementsLock(lock) {
+			if g.Config.UsesUV() {
+				locked[goarch] = lock
+			} else {
+				missing = append(missing, filename)
+			}
+			continue
+		}
+		header, err := g.Config.RequirementsLockHeader(goos, goarch)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(lock, header) {
+			return nil, fmt.Errorf("%s is out of date with the requirements in cog.yaml. Run cog lock to update it", filename)
+		}
+		locked[goarch] = lock
 	}
-	reqsString := strings.Join(reqs, "\n")
-	reqsPath := filepath.Join(g.tmpDir, "requirements.txt")
-	reqsRelPath := filepath.Join(g.relativeTmpDir, "requirements.txt")
-	if err := os.MkdirAll(filepath.Dir(reqsPath), 0o755); err != nil {
-		return "", fmt.Errorf("Failed to write %s: %w", reqsPath, err)
+	if len(locked) == 0 {
+		return nil, nil
 	}
-	if err := os.WriteFile(reqsPath, []byte(reqsString), 0644); err != nil {
-		return "", fmt.Errorf("Failed to write requirements to %s: %w", reqsPath, err)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s is missing. Run cog lock to make it", strings.Join(missing, ", "))
 	}
+	return locked, nil
+}
 
-	return fmt.Sprintf(`COPY %s /tmp/requirements.txt
-RUN --mount=type=cache,target=/root/.cache/pip pip install -r /tmp/requirements.txt && rm /tmp/requirements.txt`, reqsRelPath), nil
+func (g *Generator) hasUVLock() bool {
+	for _, filename := range []string{config.UVLockFilename, "pyproject.toml"} {
+		if _, err := os.Stat(filepath.Join(g.Dir, filename)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// uvLockRequirements installs the packages in a uv project's lockfile
+func (g *Generator) uvLockRequirements() (string, error) {
+	if len(g.Config.Build.PythonPackages) > 0 || g.Config.Build.PythonRequirements != "" {
+		console.Warnf("Installing the packages in %s instead of the Python requirements in cog.yaml", config.UVLockFilename)
+	}
+	return strings.Join([]string{
+		"COPY pyproject.toml " + config.UVLockFilename + " /tmp/uv-project/",
+		"RUN --mount=type=cache,target=/root/.cache/uv uv export --frozen --no-dev --no-emit-project --directory /tmp/uv-project -o /tmp/requirements.lock",
+		g.pipInstall("-r /tmp/requirements.lock && rm -rf /tmp/uv-project /tmp/requirements.lock"),
+	}, "\n"), nil
 }
 
 func (g *Generator) CogSHA256() string {
@@ -289,23 +868,74 @@ func (g *Generator) pipInstalls() (string, error) {
 	return strings.Join(lines, "\n"), nil
 }
 
-func (g *Generator) run() (string, error) {
-	runCommands := g.Config.Build.Run
+// run runs the commands in build.run that are in a stage, before or after Python packages are installed, and
+// the commands in build.pre_install before them
+func (g *Generator) run(beforeRequirements bool) string {
+	lines := []string{}
+	if beforeRequirements {
+		// pre_install is deprecated, and runs first like it did before run had stages
+		for _, command := range g.Config.Build.PreInstall {
+			lines = append(lines, runInstruction(config.RunItem{Command: command}))
+		}
+	}
+	for _, run := range g.Config.Build.Run {
+		if run.IsBeforeRequirements() == beforeRequirements {
+			lines = append(lines, runInstruction(run))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runInstruction returns the RUN instruction of a build.run item. Commands with several lines, environment
+// variables or a working directory are run as a script in a heredoc, which stops at the first command that fails.
+func runInstruction(run config.RunItem) string {
+	instruction := "RUN "
+	for _, mount := range run.Mounts {
+		instruction += mount.Flag() + " "
+	}
+	command := strings.TrimSpace(run.Command)
+	if !strings.Contains(command, "\n") && len(run.Env) == 0 && run.Workdir == "" {
+		return instruction + command
+	}
 
-	// For backwards compatibility
-	// runCommands = append(runCommands, g.Config.Build.PreInstall...)
+	script := []string{"set -e"}
+	if run.Workdir != "" {
+		script = append(script, "mkdir -p "+shellQuote(run.Workdir), "cd "+shellQuote(run.Workdir))
+	}
+	names := make([]string, 0, len(run.Env))
+	for name := range run.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		script = append(script, "export "+name+"="+shellQuote(run.Env[name]))
+	}
+	script = append(script, command)
 
-	lines := []string{}
-	for _, run := range runCommands {
-		command := strings.TrimSpace(run.Command)
-		if strings.Contains(command, "\n") {
-			return "", fmt.Errorf(`One of the commands in 'run' contains a new line, which won't work. You need to create a new list item in YAML prefixed with '-' for each command.
+	delimiter := heredocDelimiter(command)
+	// The delimiter is quoted so the Dockerfile doesn't expand variables, and the shell does when it runs the script
+	return instruction + "<<'" + delimiter + "'\n" + strings.Join(script, "\n") + "\n" + delimiter
+}
 
-This is the offending line: %s`, command)
+// heredocDelimiter returns a heredoc delimiter that isn't a line of the script
+func heredocDelimiter(script string) string {
+	lines := strings.Split(script, "\n")
+	for i := 0; ; i++ {
+		delimiter := "EOF"
+		if i > 0 {
+			delimiter = fmt.Sprintf("EOF%d", i)
+		}
+		found := false
+		for _, line := range lines {
+			if strings.TrimSpace(line) == delimiter {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return delimiter
 		}
-		lines = append(lines, "RUN "+command)
 	}
-	return strings.Join(lines, "\n"), nil
 }
 
 // writeTemp writes a temporary file that can be used as part of the build process
@@ -318,6 +948,9 @@ func (g *Generator) writeTemp(filename string, contents []byte) ([]string, strin
 	if err := os.WriteFile(path, contents, 0o644); err != nil {
 		return []string{}, "", fmt.Errorf("Failed to write %s: %w", filename, err)
 	}
+	if g.tmpBuildContext != "" {
+		return []string{fmt.Sprintf("COPY --from=%s %s /tmp/%s", g.tmpBuildContext, filepath.ToSlash(filename), filename)}, "/tmp/" + filename, nil
+	}
 	return []string{fmt.Sprintf("COPY %s /tmp/%s", filepath.Join(g.relativeTmpDir, filename), filename)}, "/tmp/" + filename, nil
 }
 
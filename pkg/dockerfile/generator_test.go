@@ -3,11 +3,50 @@ package dockerfile
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sieve-data/cog/pkg/config"
 )
 
+// generate validates the config, and generates a Dockerfile for a new project with it. The generator is returned
+// so tests can read the files it wrote.
+func generate(t *testing.T, cfg *config.Config) (*Generator, string) {
+	t.Helper()
+	return generateInDir(t, cfg, t.TempDir())
+}
+
+// generateInDir is generate for a project in dir, for tests that write files to the project first
+func generateInDir(t *testing.T, cfg *config.Config, dir string) (*Generator, string) {
+	t.Helper()
+	if err := cfg.ValidateAndComplete(dir); err != nil {
+		t.Fatal(err)
+	}
+	g := newGenerator(t, cfg, dir)
+	str, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, str
+}
+
+// newGenerator returns a generator for a project in dir that looks up versions in testVersions instead of PyPI
+func newGenerator(t *testing.T, cfg *config.Config, dir string) *Generator {
+	t.Helper()
+	g, err := NewGenerator(cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.resolveVersions = testVersions
+	return g
+}
+
+// testVersions are the versions on PyPI of every package in tests
+func testVersions(name string, python string) ([]string, error) {
+	return []string{"0.3.11", "0.3.12", "0.4.0rc1"}, nil
+}
+
 func TestGenerate(t *testing.T) {
 	config := &config.Config{
 		Build: &config.Build{
@@ -28,17 +67,1077 @@ func TestGenerate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	g, err := NewGenerator(config, tmpDir)
+	g := newGenerator(t, config, tmpDir)
+
+	str, err := g.Generate()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Generated Dockerfile content:")
+	t.Log(str)
+}
+
+func TestGenerateMultiPlatform(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.10",
+			PythonPackages: []string{
+				"torch==2.3.1",
+			},
+			Platforms: []string{"linux/amd64", "linux/arm64"},
+		},
+	}
+	g, str := generate(t, config)
+
+	for _, expected := range []string{
+		"ENV LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/usr/lib/x86_64-linux-gnu:/usr/lib/aarch64-linux-gnu:/usr/local/nvidia/lib64:/usr/local/nvidia/bin",
+		"COPY --from=cog-tmp requirements-amd64.txt /tmp/requirements-amd64.txt",
+		"COPY --from=cog-tmp requirements-arm64.txt /tmp/requirements-arm64.txt",
+		"ARG TARGETARCH\nRUN --mount=type=cache,target=/root/.cache/pip pip install -r /tmp/requirements-${TARGETARCH}.txt",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain %q:\n%s", expected, str)
+		}
+	}
+
+	amd64Requirements, err := os.ReadFile(filepath.Join(g.tmpDir, "requirements-amd64.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(amd64Requirements), "torch==2.3.1+cpu") {
+		t.Fatalf("Expected amd64 requirements to install the CPU build of torch:\n%s", amd64Requirements)
+	}
+	arm64Requirements, err := os.ReadFile(filepath.Join(g.tmpDir, "requirements-arm64.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(arm64Requirements), "+cpu") {
+		t.Fatalf("Expected arm64 requirements to install torch without the +cpu suffix:\n%s", arm64Requirements)
+	}
+}
+
+func TestGenerateWithArtifactsDir(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.10",
+			CUDA:          "12.1",
+			Artifacts:     &config.Artifacts{Dir: "artifacts"},
+		},
+	}
+	tmpDir := t.TempDir()
+	for _, path := range []string{"artifacts/tini/v0.19.0/tini-amd64", "artifacts/pyenv/pyenv-2.6.8.tar.gz", "artifacts/python/Python-3.10.14.tar.xz"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, path), []byte(""), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, str := generateInDir(t, config, tmpDir)
+
+	for _, expected := range []string{
+		"COPY artifacts/tini/v0.19.0/tini-amd64 /tmp/tini/tini-amd64",
+		`echo "${TINI_SHA256}  /sbin/tini" | sha256sum -c -`,
+		"COPY artifacts/pyenv/pyenv-2.6.8.tar.gz /tmp/pyenv.tar.gz",
+		`echo "` + pyenvSHA256 + `  /tmp/pyenv.tar.gz" | sha256sum -c -`,
+		"COPY artifacts/python /root/.pyenv/cache",
+		`PYTHON_BUILD_CACHE_PATH=/root/.pyenv/cache pyenv install "$PYTHON_VERSION"`,
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain %q:\n%s", expected, str)
+		}
+	}
+	for _, unexpected := range []string{"curl -sSL", "github.com"} {
+		if strings.Contains(str, unexpected) {
+			t.Fatalf("Expected Dockerfile not to contain %q:\n%s", unexpected, str)
+		}
+	}
+}
+
+func TestGenerateWithArtifactsDirMissingArtifact(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.10",
+			Platforms:     []string{"linux/amd64", "linux/arm64"},
+			Artifacts:     &config.Artifacts{Dir: "artifacts"},
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "artifacts/tini/v0.19.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "artifacts/tini/v0.19.0/tini-amd64"), []byte(""), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := newGenerator(t, config, tmpDir)
+	_, err := g.Generate()
+	if err == nil || !strings.Contains(err.Error(), "artifacts/tini/v0.19.0/tini-arm64 is missing from artifacts.dir in cog.yaml. Download it from https://github.com/krallin/tini/releases/download/v0.19.0/tini-arm64") {
+		t.Fatalf("Expected missing tini error, got %v", err)
+	}
+}
+
+func TestGenerateWithArtifactsMirror(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.10",
+			CUDA:          "12.1",
+			Artifacts:     &config.Artifacts{Mirror: "https://mirror.example.com/cog/"},
+		},
+	}
+	_, str := generate(t, config)
+
+	for _, expected := range []string{
+		`curl -sSL -o /sbin/tini "https://mirror.example.com/cog/tini/v0.19.0/tini-${TINI_ARCH}"`,
+		`curl -sSL -o /tmp/pyenv.tar.gz "https://mirror.example.com/cog/pyenv/pyenv-2.6.8.tar.gz"`,
+		`PYTHON_BUILD_MIRROR_URL=https://mirror.example.com/cog/python PYTHON_BUILD_MIRROR_URL_SKIP_CHECKSUM=1 pyenv install`,
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain %q:\n%s", expected, str)
+		}
+	}
+}
+
+func TestGeneratePythonInstallStandalone(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			CUDA:          "12.1",
+			PythonInstall: config.PythonInstallStandalone,
+		},
+	}
+	_, str := generate(t, config)
+
+	for _, expected := range []string{
+		`ENV PATH="/root/.python/bin:$PATH"`,
+		"RUN --mount=type=cache,target=/root/.cache/python-build-standalone set -eux;",
+		`PYTHON_FILENAME="cpython-3.11.9+20240814-${PYTHON_TRIPLE}-install_only.tar.gz"`,
+		`curl -sSfL -o SHA256SUMS "https://github.com/indygreg/python-build-standalone/releases/download/20240814/SHA256SUMS"`,
+		`grep " ${PYTHON_FILENAME}$" SHA256SUMS | sha256sum -c -`,
+		"ln -sf python3 /root/.python/bin/python",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain %q:\n%s", expected, str)
+		}
+	}
+	if strings.Contains(str, "pyenv") {
+		t.Fatalf("Expected Dockerfile not to use pyenv:\n%s", str)
+	}
+}
+
+func TestGeneratePythonInstallStandaloneUnavailableVersion(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.11.2",
+			CUDA:          "12.1",
+			PythonInstall: config.PythonInstallStandalone,
+		},
+	}
+	g := newGenerator(t, config, t.TempDir())
+	_, err := g.Generate()
+	if err == nil || !strings.Contains(err.Error(), "python_install: standalone only has Python 3.11.9, not 3.11.2") {
+		t.Fatalf("Expected unavailable version error, got %v", err)
+	}
+}
+
+func TestGeneratePythonInstallUV(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			CUDA:          "12.1",
+			PythonInstall: config.PythonInstallUV,
+		},
+	}
+	_, str := generate(t, config)
+
+	for _, expected := range []string{
+		`ENV PATH="/root/.python/bin:$PATH"`,
+		"COPY --from=ghcr.io/astral-sh/uv:0.4.18 /uv /usr/local/bin/uv",
+		"RUN --mount=type=cache,target=/root/.cache/uv set -eux;",
+		`uv python install "3.11"`,
+		"rm -f /root/.python/lib/python*/EXTERNALLY-MANAGED",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain %q:\n%s", expected, str)
+		}
+	}
+}
+
+func TestGenerateInstallerUV(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+			PythonPackages: []string{
+				"ffmpeg-python==0.2.0",
+			},
+			Installer: config.InstallerUV,
+		},
+	}
+	_, str := generate(t, config)
+
+	for _, expected := range []string{
+		"COPY --from=ghcr.io/astral-sh/uv:0.4.18 /uv /usr/local/bin/uv",
+		"RUN --mount=type=cache,target=/root/.cache/uv uv pip install --system -r /tmp/requirements.txt && rm /tmp/requirements.txt",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain %q:\n%s", expected, str)
+		}
+	}
+	if strings.Contains(str, "pip install -r") || strings.Contains(str, "pyenv rehash") {
+		t.Fatalf("Expected Dockerfile to only install packages with uv:\n%s", str)
+	}
+}
+
+func TestGenerateRequirementsLock(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+			PythonPackages: []string{
+				"ffmpeg-python==0.2.0",
+			},
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := config.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	header, err := config.RequirementsLockHeader("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := header + "ffmpeg-python==0.2.0 \\\n    --hash=sha256:abc\nfuture==1.0.0 \\\n    --hash=sha256:def\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "requirements.lock"), []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, str := generateInDir(t, config, tmpDir)
+	if !strings.Contains(str, "pip install -r /tmp/requirements.txt") {
+		t.Fatalf("Expected Dockerfile to install requirements:\n%s", str)
+	}
+	installed, err := os.ReadFile(filepath.Join(g.tmpDir, "requirements.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(installed) != lock {
+		t.Fatalf("Expected the lockfile to be installed, got:\n%s", installed)
+	}
+
+	// Changing the requirements makes the lockfile out of date
+	config.Build.PythonPackages = []string{"ffmpeg-python==0.2.1"}
+	if err := config.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.Generate()
+	if err == nil || !strings.Contains(err.Error(), "requirements.lock is out of date with the requirements in cog.yaml. Run cog lock to update it") {
+		t.Fatalf("Expected out of date lockfile error, got %v", err)
+	}
+}
+
+func TestGenerateRequirementsLockGPU(t *testing.T) {
+	// GPU and slim builds validate the config again while generating, which mustn't change the requirements
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:                true,
+			PythonVersion:      "3.11",
+			PythonRequirements: "requirements.txt",
+			Slim:               true,
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "requirements.txt"), []byte("torch==2.3.1\nnumpy==1.26.4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	header, err := config.RequirementsLockHeader("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := header + "numpy==1.26.4\ntorch==2.3.1+cu121\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "requirements.lock"), []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, _ := generateInDir(t, config, tmpDir)
+	installed, err := os.ReadFile(filepath.Join(g.tmpDir, "requirements.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(installed) != lock {
+		t.Fatalf("Expected the lockfile to be installed, got:\n%s", installed)
+	}
+}
+
+func TestGenerateRequirementsLockFromOtherTool(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+			PythonPackages: []string{
+				"ffmpeg-python==0.2.0",
+			},
+		},
+	}
+	tmpDir := t.TempDir()
+	lock := "# This file was autogenerated by uv via the following command:\nffmpeg-python==0.2.0\nfuture==1.0.0\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "requirements.lock"), []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A requirements.lock that cog lock didn't write is ignored
+	g, _ := generateInDir(t, config, tmpDir)
+	installed, err := os.ReadFile(filepath.Join(g.tmpDir, "requirements.txt"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if strings.TrimSpace(string(installed)) != "ffmpeg-python==0.2.0" {
+		t.Fatalf("Expected the requirements in cog.yaml to be installed, got:\n%s", installed)
+	}
 
+	// With uv, it is installed as it is
+	config.Build.Installer = "uv"
+	g, _ = generateInDir(t, config, tmpDir)
+	installed, err = os.ReadFile(filepath.Join(g.tmpDir, "requirements.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(installed) != lock {
+		t.Fatalf("Expected the lockfile to be installed, got:\n%s", installed)
+	}
+}
+
+func TestGenerateUVLock(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			CUDA:          "12.1",
+			Installer:     config.InstallerUV,
+		},
+	}
+	tmpDir := t.TempDir()
+	for _, filename := range []string{"pyproject.toml", "uv.lock"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, filename), []byte(""), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, str := generateInDir(t, config, tmpDir)
+
+	for _, expected := range []string{
+		"COPY pyproject.toml uv.lock /tmp/uv-project/",
+		"uv export --frozen --no-dev --no-emit-project --directory /tmp/uv-project -o /tmp/requirements.lock",
+		"uv pip install --system -r /tmp/requirements.lock && rm -rf /tmp/uv-project /tmp/requirements.lock && pyenv rehash",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain %q:\n%s", expected, str)
+		}
+	}
+}
+
+func TestGenerateReproduciblePinsImages(t *testing.T) {
+	config.BuildReproducible = true
+	defer func() { config.BuildReproducible = false }()
+
+	cfg := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+			Installer:     config.InstallerUV,
+			Sievedata:     &config.ExtraPackage{Requirement: "1.0.0"},
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := cfg.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	g := newGenerator(t, cfg, tmpDir)
+	resolved := []string{}
+	g.resolveDigest = func(image string) (string, error) {
+		resolved = append(resolved, image)
+		return "sha256:" + strings.Repeat("a", 64), nil
+	}
 	str, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	for _, expected := range []string{
+		"# syntax = docker/dockerfile:1.4" + digest,
+		"FROM python:3.11" + digest,
+		"COPY --from=ghcr.io/astral-sh/uv:0.4.18" + digest + " /uv /usr/local/bin/uv",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain %q:\n%s", expected, str)
+		}
+	}
+	if len(g.PinnedImages()) != 3 || g.PinnedImages()["python:3.11"] != "python:3.11"+digest {
+		t.Fatalf("Unexpected pinned images: %v", g.PinnedImages())
+	}
+
+	// Images are only resolved once
+	if _, err := g.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(resolved) != 3 {
+		t.Fatalf("Expected 3 images to be resolved, got %v", resolved)
+	}
+}
+
+func TestGenerateUser(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			CUDA:          "12.1",
+			User:          &config.User{Name: "cog"},
+		},
+	}
+	_, str := generate(t, config)
+
+	expected := `RUN groupadd --non-unique --gid 1000 cog && \
+useradd --no-log-init --non-unique --uid 1000 --gid 1000 --create-home --shell /bin/bash cog && \
+mkdir -p /src /home/cog/.cache && \
+chown 1000:1000 /src /home/cog/.cache && \
+chmod o+x /root
+WORKDIR /src
+USER 1000:1000`
+	if !strings.Contains(str, expected) {
+		t.Fatalf("Expected Dockerfile to contain:\n%s\n\nGot:\n%s", expected, str)
+	}
+}
+
+func TestGenerateExtras(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+			Sievedata:     &config.ExtraPackage{Requirement: ">=0.3,<0.3.12"},
+			Cython:        &config.ExtraPackage{Disabled: true},
+		},
+	}
+	g, str := generate(t, config)
 
+	if strings.Contains(str, "cython") {
+		t.Fatalf("Expected Dockerfile not to install Cython:\n%s", str)
+	}
+	expected := `RUN --mount=type=cache,target=/root/.cache/pip pip install 'sievedata==0.3.11'
+COPY --from=cog-tmp check_sievedata.py /tmp/check_sievedata.py
+RUN python /tmp/check_sievedata.py && rm /tmp/check_sievedata.py`
+	if !strings.Contains(str, expected) {
+		t.Fatalf("Expected Dockerfile to contain:\n%s\n\nGot:\n%s", expected, str)
+	}
+	if extras := g.ExtraRequirements(); len(extras) != 2 || extras["sievedata"] != "sievedata==0.3.11" {
+		t.Fatalf("Unexpected extra requirements: %v", extras)
+	}
+
+	script, err := os.ReadFile(filepath.Join(g.tmpDir, "check_sievedata.py"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !strings.Contains(string(script), `requires("cog")`) {
+		t.Fatalf("Expected check to read cog's requirements:\n%s", script)
+	}
+}
 
-	t.Log("Generated Dockerfile content:")
-	t.Log(str)
+func TestGenerateExtrasUnpinnedSievedata(t *testing.T) {
+	cfg := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+		},
+	}
+	tmpDir := t.TempDir()
+	g, str := generateInDir(t, cfg, tmpDir)
+
+	// Pre-releases aren't installed unless they are asked for
+	if !strings.Contains(str, "pip install 'sievedata==0.3.12'") {
+		t.Fatalf("Expected sievedata to be pinned to the latest version:\n%s", str)
+	}
+	extras := g.ExtraRequirements()
+	if extras["sievedata"] != "sievedata==0.3.12" || extras["cython"] != "cython==0.29.34" {
+		t.Fatalf("Unexpected extra requirements: %v", extras)
+	}
+
+	// Reproducible builds can't install whatever version is latest when they are built
+	config.BuildReproducible = true
+	defer func() { config.BuildReproducible = false }()
+	g = newGenerator(t, cfg, tmpDir)
+	g.resolveDigest = func(image string) (string, error) {
+		return "sha256:" + strings.Repeat("a", 64), nil
+	}
+	_, err := g.Generate()
+	if err == nil || !strings.Contains(err.Error(), "sievedata must be pinned to a version for a reproducible build") {
+		t.Fatalf("Expected unpinned sievedata error, got %v", err)
+	}
+}
+
+func TestGenerateExtrasNoMatchingVersion(t *testing.T) {
+	cfg := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+			Sievedata:     &config.ExtraPackage{Requirement: ">=1.0"},
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := cfg.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	_, err := newGenerator(t, cfg, tmpDir).Generate()
+	if err == nil || !strings.Contains(err.Error(), "No version of sievedata on PyPI that supports Python 3.11 satisfies sievedata>=1.0") {
+		t.Fatalf("Expected an error about sievedata's versions, got %v", err)
+	}
+}
+
+func TestParsePyPIVersions(t *testing.T) {
+	body := `{"releases": {
+  "0.3.10": [{"requires_python": ">=3.7", "yanked": false}],
+  "0.3.11": [{"requires_python": ">=3.7", "yanked": true}],
+  "0.3.12": [{"requires_python": ">=3.12", "yanked": false}, {"requires_python": null, "yanked": true}],
+  "0.3.13": [{"requires_python": ">= 3.8, !=3.9.*", "yanked": false}],
+  "0.3.14": []
+}}`
+	versions, err := parsePyPIVersions(strings.NewReader(body), "3.11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Yanked versions, versions without files, and versions for other Python versions are left out
+	if strings.Join(versions, ",") != "0.3.10,0.3.13" {
+		t.Fatalf("Unexpected versions: %v", versions)
+	}
+
+	versions, err = parsePyPIVersions(strings.NewReader(body), "3.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(versions, ",") != "0.3.10" {
+		t.Fatalf("Unexpected versions: %v", versions)
+	}
+}
+
+func TestCogWheelVersion(t *testing.T) {
+	version, err := cogWheelVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(version, "0.") {
+		t.Fatalf("Unexpected cog wheel version %q", version)
+	}
+}
+
+func TestGenerateCogRuntime(t *testing.T) {
+	for _, tt := range []struct {
+		runtime  string
+		expected string
+		extra    string
+	}{
+		{
+			runtime: "",
+			expected: `COPY --from=cog-tmp cog-0.0.1.dev-py3-none-any.whl /tmp/cog-0.0.1.dev-py3-none-any.whl
+RUN --mount=type=cache,target=/root/.cache/pip pip install /tmp/cog-0.0.1.dev-py3-none-any.whl && rm /tmp/cog-0.0.1.dev-py3-none-any.whl`,
+			extra: "cog==0.",
+		},
+		{
+			runtime:  "pypi==0.9.14",
+			expected: "RUN --mount=type=cache,target=/root/.cache/pip pip install 'cog==0.9.14'",
+			extra:    "cog==0.9.14",
+		},
+		{
+			runtime:  "pypi>=0.3",
+			expected: "RUN --mount=type=cache,target=/root/.cache/pip pip install 'cog==0.3.12'",
+			extra:    "cog==0.3.12",
+		},
+		{
+			runtime: "vendor/cog-0.9.14-py3-none-any.whl",
+			expected: `COPY vendor/cog-0.9.14-py3-none-any.whl /tmp/cog-runtime/cog-0.9.14-py3-none-any.whl
+RUN --mount=type=cache,target=/root/.cache/pip pip install /tmp/cog-runtime/cog-0.9.14-py3-none-any.whl && rm -rf /tmp/cog-runtime/cog-0.9.14-py3-none-any.whl`,
+			extra: "vendor/cog-0.9.14-py3-none-any.whl",
+		},
+	} {
+		config := &config.Config{
+			Build: &config.Build{
+				PythonVersion: "3.11",
+				CogRuntime:    tt.runtime,
+			},
+		}
+		tmpDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(tmpDir, "vendor"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "vendor/cog-0.9.14-py3-none-any.whl"), []byte{}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		g, str := generateInDir(t, config, tmpDir)
+		if !strings.Contains(str, tt.expected) {
+			t.Fatalf("Expected Dockerfile to contain:\n%s\n\nGot:\n%s", tt.expected, str)
+		}
+		if !strings.Contains(str, `RUN python -c "import cog.server.http"`) {
+			t.Fatalf("Expected Dockerfile to check cog.server.http can be imported:\n%s", str)
+		}
+		if !strings.HasPrefix(g.ExtraRequirements()["cog"], tt.extra) {
+			t.Fatalf("Expected cog to be recorded as %q, got %q", tt.extra, g.ExtraRequirements()["cog"])
+		}
+	}
+}
+
+func TestGenerateSlim(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion:  "3.11",
+			Slim:           true,
+			SystemPackages: []config.SystemPackage{{Name: "ffmpeg"}},
+			Run:            []config.RunItem{{Command: "echo hello"}},
+		},
+	}
+	_, str := generate(t, config)
+
+	for _, expected := range []string{
+		"FROM python:3.11 AS builder\n",
+		"find /usr/local -type f",
+		"| sort -u > /tmp/runtime-packages.txt\nFROM python:3.11-slim\n",
+		`COPY --from=builder /tmp/runtime-packages.txt /tmp/runtime-packages.txt
+RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && \
+xargs -r -a /tmp/runtime-packages.txt apt-get install -qqy --no-install-recommends && \
+rm -rf /var/lib/apt/lists/* /tmp/runtime-packages.txt
+COPY --from=builder /sbin/tini /sbin/tini
+ENTRYPOINT ["/sbin/tini", "--"]
+COPY --from=builder /usr/local /usr/local
+RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get install -qqy ffmpeg && rm -rf /var/lib/apt/lists/*
+RUN echo hello`,
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain:\n%s\n\nGot:\n%s", expected, str)
+		}
+	}
+	if strings.Count(str, "ffmpeg") != 2 {
+		t.Fatalf("Expected system packages to be installed in both stages, got:\n%s", str)
+	}
+}
+
+func TestGenerateSlimGPU(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			CUDA:          "12.1",
+			Slim:          true,
+		},
+	}
+	_, str := generate(t, config)
+
+	runtimeImage, err := config.CUDARuntimeImageTag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(runtimeImage, "-runtime-") {
+		t.Fatalf("Expected a runtime image, got %s", runtimeImage)
+	}
+	for _, expected := range []string{
+		" AS builder\n",
+		"find /root/.pyenv -type f",
+		"FROM " + runtimeImage + "\n",
+		`ENV PATH="/root/.pyenv/shims:/root/.pyenv/bin:$PATH"
+COPY --from=builder /tmp/runtime-packages.txt /tmp/runtime-packages.txt`,
+		"apt-get install -qqy --no-install-recommends ca-certificates",
+		"COPY --from=builder /root/.pyenv /root/.pyenv",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain:\n%s\n\nGot:\n%s", expected, str)
+		}
+	}
+}
+
+func TestGenerateBaseImage(t *testing.T) {
+	for _, tt := range []struct {
+		baseImage string
+		expected  []string
+	}{
+		{
+			baseImage: "python:3.11-slim-bookworm",
+			expected: []string{
+				"FROM python:3.11-slim-bookworm\n",
+				`TINI_ARCH="$(dpkg --print-architecture)"`,
+				"RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get install -qqy ffmpeg && rm -rf /var/lib/apt/lists/*",
+				"RUN groupadd",
+			},
+		},
+		{
+			baseImage: "python:3.11-alpine",
+			expected: []string{
+				"FROM python:3.11-alpine\n",
+				"RUN apk add --no-cache tini\n",
+				"RUN apk add --no-cache ffmpeg\n",
+				"RUN addgroup -g 1000 cog && \\\nadduser -D -u 1000 -G cog -s /bin/sh cog",
+			},
+		},
+		{
+			baseImage: "registry.access.redhat.com/ubi9/python-311",
+			expected: []string{
+				"FROM registry.access.redhat.com/ubi9/python-311\n",
+				"command -v curl >/dev/null || dnf install -y curl",
+				`TINI_ARCH="$(uname -m | sed -e 's/x86_64/amd64/' -e 's/aarch64/arm64/')"`,
+				"RUN --mount=type=cache,target=/var/cache/dnf dnf install -y --setopt=install_weak_deps=False ffmpeg\n",
+			},
+		},
+	} {
+		config := &config.Config{
+			Build: &config.Build{
+				PythonVersion:  "3.11",
+				BaseImage:      tt.baseImage,
+				SystemPackages: []config.SystemPackage{{Name: "ffmpeg"}},
+				User:           &config.User{Name: "cog"},
+			},
+		}
+		_, str := generate(t, config)
+		for _, expected := range tt.expected {
+			if !strings.Contains(str, expected) {
+				t.Fatalf("Expected Dockerfile for %s to contain:\n%s\n\nGot:\n%s", tt.baseImage, expected, str)
+			}
+		}
+	}
+}
+
+func TestDetectDistro(t *testing.T) {
+	for _, tt := range []struct {
+		image    string
+		expected distro
+	}{
+		{"python:3.11", distroDebian},
+		{"python:3.11-slim-bookworm", distroDebian},
+		{"mirror.example.com/library/python:3.11", distroDebian},
+		{"alpine.example.com/python:3.11", distroDebian},
+		{"python:3.11-alpine3.19", distroAlpine},
+		{"localhost:5000/python:3.11-alpine", distroAlpine},
+		{"registry.access.redhat.com/ubi9/python-311", distroRHEL},
+		{"fedora:40", distroRHEL},
+		{"python@sha256:0123456789abcdef", distroDebian},
+	} {
+		if actual := detectDistro(tt.image); actual != tt.expected {
+			t.Fatalf("Expected %s to be %s, got %s", tt.image, tt.expected, actual)
+		}
+	}
+}
+
+func TestGenerateSystemPackages(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			GPU:           true,
+			PythonVersion: "3.11",
+			CUDA:          "12.1",
+			Slim:          true,
+			SystemPackages: []config.SystemPackage{
+				{Name: "ffmpeg", Version: "7:6.1.1-1", Repository: "ppa:savoury1/ffmpeg6"},
+				{Name: "nvtop", Repository: "deb https://example.com/apt stable main", Key: "https://example.com/apt/key.gpg"},
+				{Name: "libavcodec-dev", BuildOnly: true},
+				{Deb: "vendor/tool_1.0_amd64.deb"},
+			},
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "vendor"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "vendor/tool_1.0_amd64.deb"), []byte{}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, str := generateInDir(t, config, tmpDir)
+
+	repositories := `RUN --mount=type=cache,target=/var/cache/apt set -eux; \
+apt-get update -qq; \
+apt-get install -qqy --no-install-recommends ca-certificates curl gnupg software-properties-common; \
+mkdir -p /etc/apt/keyrings; \
+add-apt-repository -y ppa:savoury1/ffmpeg6; \
+curl -fsSL 'https://example.com/apt/key.gpg' | gpg --dearmor -o /etc/apt/keyrings/cog-1.gpg; \
+echo 'deb [signed-by=/etc/apt/keyrings/cog-1.gpg] https://example.com/apt stable main' >> /etc/apt/sources.list.d/cog.list; \
+rm -rf /var/lib/apt/lists/*`
+	builder := "RUN --mount=type=bind,source=vendor/tool_1.0_amd64.deb,target=/tmp/debs/tool_1.0_amd64.deb --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get install -qqy ffmpeg=7:6.1.1-1 nvtop libavcodec-dev /tmp/debs/tool_1.0_amd64.deb && rm -rf /var/lib/apt/lists/*"
+	runtime := "RUN --mount=type=bind,source=vendor/tool_1.0_amd64.deb,target=/tmp/debs/tool_1.0_amd64.deb --mount=type=cache,target=/var/cache/apt apt-get update -qq && apt-get install -qqy ffmpeg=7:6.1.1-1 nvtop /tmp/debs/tool_1.0_amd64.deb && rm -rf /var/lib/apt/lists/*"
+	for _, expected := range []string{repositories + "\n" + builder, repositories + "\n" + runtime} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain:\n%s\n\nGot:\n%s", expected, str)
+		}
+	}
+}
+
+func TestGenerateSystemPackagesAlpine(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion:  "3.11",
+			BaseImage:      "python:3.11-alpine",
+			SystemPackages: []config.SystemPackage{{Name: "nvtop", Repository: "deb https://example.com/apt stable main"}},
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := config.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	g := newGenerator(t, config, tmpDir)
+	_, err := g.Generate()
+	if err == nil || !strings.Contains(err.Error(), "can only be used with Debian and Ubuntu images") {
+		t.Fatalf("Expected an error about repositories on Alpine, got %v", err)
+	}
+}
+
+func TestGenerateRun(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion:  "3.11",
+			PythonPackages: []string{"torch==2.0.1"},
+			Run: []config.RunItem{
+				{Command: "echo after"},
+				{Command: "echo one\nEOF\necho two\n"},
+				{
+					Command: "make install",
+					Workdir: "/opt/tool",
+					Env:     map[string]string{"PREFIX": "/usr/local", "CC": "gcc"},
+					Mounts:  []config.RunMount{{Type: config.RunMountCache, Target: "/root/.cache/tool"}},
+					Stage:   config.RunStageBeforeRequirements,
+				},
+			},
+		},
+	}
+	_, str := generate(t, config)
+
+	before := `RUN --mount=type=cache,target=/root/.cache/tool <<'EOF'
+set -e
+mkdir -p '/opt/tool'
+cd '/opt/tool'
+export CC='gcc'
+export PREFIX='/usr/local'
+make install
+EOF`
+	after := `RUN echo after
+RUN <<'EOF1'
+set -e
+echo one
+EOF
+echo two
+EOF1`
+	for _, expected := range []string{before, after} {
+		if !strings.Contains(str, expected) {
+			t.Fatalf("Expected Dockerfile to contain:\n%s\n\nGot:\n%s", expected, str)
+		}
+	}
+	requirements := strings.Index(str, "requirements.txt")
+	if requirements < 0 || strings.Index(str, before) > requirements || strings.Index(str, after) < requirements {
+		t.Fatalf("Expected before_requirements commands before Python packages, and other commands after them, got:\n%s", str)
+	}
+}
+
+func TestGeneratePreInstall(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion:  "3.11",
+			PythonPackages: []string{"torch==2.0.1"},
+			PreInstall:     []string{"echo pre"},
+			Run:            []config.RunItem{{Command: "echo before", Stage: config.RunStageBeforeRequirements}},
+		},
+	}
+	_, str := generate(t, config)
+
+	expected := "RUN echo pre\nRUN echo before\n"
+	index := strings.Index(str, expected)
+	if index < 0 || index > strings.Index(str, "requirements.txt") {
+		t.Fatalf("Expected pre_install to run before Python packages are installed, got:\n%s", str)
+	}
+}
+
+func TestExportContext(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion:  "3.11",
+			PythonPackages: []string{"torch==2.0.1"},
+		},
+	}
+	tmpDir := t.TempDir()
+	for name, contents := range map[string]string{
+		"predict.py":          "class Predictor: pass",
+		"weights/model.bin":   "weights",
+		".git/HEAD":           "ref: refs/heads/main",
+		".cog/openapi_schema": "{}",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, str := generateInDir(t, config, tmpDir)
+
+	// The context directory can be in the project, and isn't copied into itself
+	contextDir := filepath.Join(tmpDir, "out")
+	command, err := g.ExportContext(contextDir, str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCommand := "docker build --build-context cog-tmp=" + filepath.Join(contextDir, g.relativeTmpDir) + " " + contextDir
+	if command != expectedCommand {
+		t.Fatalf("Expected build command %q, got %q", expectedCommand, command)
+	}
+	if err := g.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	dockerfile, err := os.ReadFile(filepath.Join(contextDir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dockerfile) != str+"\n" {
+		t.Fatalf("Expected the Dockerfile to be exported, got:\n%s", dockerfile)
+	}
+	for _, name := range []string{"predict.py", "weights/model.bin", filepath.Join(g.relativeTmpDir, "requirements.txt")} {
+		if _, err := os.Stat(filepath.Join(contextDir, name)); err != nil {
+			t.Fatalf("Expected %s to be exported: %s", name, err)
+		}
+	}
+	for _, name := range []string{".git", ".cog/openapi_schema", "out"} {
+		if _, err := os.Stat(filepath.Join(contextDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s not to be exported", name)
+		}
+	}
+
+	if _, err := g.ExportContext(contextDir, str); err == nil || !strings.Contains(err.Error(), "isn't empty") {
+		t.Fatalf("Expected an error exporting to a directory that isn't empty, got %v", err)
+	}
+}
+
+func TestNewGeneratorTmpDir(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := config.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Builds of the same project at the same time have their own directories
+	first := newGenerator(t, config, tmpDir)
+	second := newGenerator(t, config, tmpDir)
+	if first.tmpDir == second.tmpDir {
+		t.Fatalf("Expected generators to have different temporary directories, both got %s", first.tmpDir)
+	}
+	if !strings.HasPrefix(first.relativeTmpDir, ".cog/tmp/build-") {
+		t.Fatalf("Unexpected temporary directory %s", first.relativeTmpDir)
+	}
+	if _, err := first.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{first.tmpDir, first.tmpDir + ".lock"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be removed", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(second.tmpDir, "cog-0.0.1.dev-py3-none-any.whl")); err != nil {
+		t.Fatalf("Expected cleaning up one generator not to remove the files of another: %s", err)
+	}
+
+	// A generator that is still running isn't cleaned up by a new one
+	third := newGenerator(t, config, tmpDir)
+	if _, err := os.Stat(second.tmpDir); err != nil {
+		t.Fatalf("Expected %s to be kept while it is locked: %s", second.tmpDir, err)
+	}
+	if err := second.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := third.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewGeneratorRemovesStaleTmpDirs(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := config.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(tmpDir, ".cog/tmp")
+	old := time.Now().Add(-2 * unlockedTmpDirMaxAge)
+	for _, name := range []string{"build-crashed", "build-unlocked-old", "build-unlocked-new", "build"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The lock of a cog that has exited isn't held
+	for _, name := range []string{"build-crashed.lock", "build-orphan.lock"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(root, "build-unlocked-old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	g := newGenerator(t, config, tmpDir)
+	defer g.Cleanup()
+
+	for _, name := range []string{"build-crashed", "build-crashed.lock", "build-orphan.lock", "build-unlocked-old"} {
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be removed", name)
+		}
+	}
+	// A directory without a lock may have just been made by another cog, and directories of older versions of
+	// Cog are left alone
+	for _, name := range []string{"build-unlocked-new", "build"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Fatalf("Expected %s to be kept: %s", name, err)
+		}
+	}
+}
+
+func TestWriteDockerfile(t *testing.T) {
+	config := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, ".dockerignore"), []byte("weights"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, str := generateInDir(t, config, tmpDir)
+	defer g.Cleanup()
+
+	dockerfile, err := g.WriteDockerfile(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dockerfile.Path != filepath.Join(g.relativeTmpDir, "Dockerfile") {
+		t.Fatalf("Unexpected Dockerfile path %s", dockerfile.Path)
+	}
+	if dockerfile.Contexts["cog-tmp"] != g.relativeTmpDir {
+		t.Fatalf("Expected the temporary directory to be passed as a build context, got %v", dockerfile.Contexts)
+	}
+	written, err := os.ReadFile(filepath.Join(tmpDir, dockerfile.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != str+"\n" {
+		t.Fatalf("Expected the Dockerfile to be written, got:\n%s", written)
+	}
+	dockerignore, err := os.ReadFile(filepath.Join(tmpDir, dockerfile.Path+".dockerignore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(dockerignore), "weights\n") || !strings.HasSuffix(string(dockerignore), "\n.cog\n") {
+		t.Fatalf("Expected the ignore file to extend the project's .dockerignore and ignore .cog, got:\n%s", dockerignore)
+	}
 }
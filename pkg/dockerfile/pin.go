@@ -0,0 +1,48 @@
+package dockerfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/config"
+)
+
+// dockerfileFrontend is the image BuildKit parses the Dockerfile with
+const dockerfileFrontend = "docker/dockerfile:1.4"
+
+// pinImages resolves images to digests when config.BuildReproducible is set, so every build uses the same
+// images even if their tags move. Images that already have a digest are used as they are.
+func (g *Generator) pinImages(images ...string) error {
+	if !config.BuildReproducible {
+		return nil
+	}
+	for _, image := range images {
+		if _, ok := g.pinnedImages[image]; ok || strings.Contains(image, "@") {
+			continue
+		}
+		digest, err := g.resolveDigest(image)
+		if err != nil {
+			return fmt.Errorf("Failed to resolve %s to a digest: %w", image, err)
+		}
+		g.pinnedImages[image] = image + "@" + digest
+	}
+	return nil
+}
+
+// imageRef returns an image with its digest if it has been pinned
+func (g *Generator) imageRef(image string) string {
+	if pinned, ok := g.pinnedImages[image]; ok {
+		return pinned
+	}
+	return image
+}
+
+// PinnedImages returns the images the Dockerfile was pinned to, by the names they were resolved from
+func (g *Generator) PinnedImages() map[string]string {
+	return g.pinnedImages
+}
+
+// usesUVImage returns whether uv is copied into the image
+func (g *Generator) usesUVImage() bool {
+	return g.Config.UsesUV() || (g.Config.Build.GPU && g.Config.Build.PythonInstall == config.PythonInstallUV)
+}
@@ -0,0 +1,68 @@
+package dockerfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/sieve-data/cog/pkg/config"
+)
+
+// pypiURL is where the versions of the packages Cog installs besides the model's requirements are looked up
+const pypiURL = "https://pypi.org/pypi"
+
+// pypiVersions returns the versions of a package on PyPI that can be installed on a Python version
+func pypiVersions(name string, python string) ([]string, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(pypiURL + "/" + url.PathEscape(name) + "/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("PyPI returned %s", resp.Status)
+	}
+	return parsePyPIVersions(resp.Body, python)
+}
+
+// parsePyPIVersions returns the versions in a response from PyPI's JSON API that can be installed on a Python
+// version. Like pip, it leaves out versions that have been yanked, or only have files for other Python versions.
+func parsePyPIVersions(r io.Reader, python string) ([]string, error) {
+	var project struct {
+		Releases map[string][]struct {
+			RequiresPython string `json:"requires_python"`
+			Yanked         bool   `json:"yanked"`
+		} `json:"releases"`
+	}
+	if err := json.NewDecoder(r).Decode(&project); err != nil {
+		return nil, fmt.Errorf("Failed to parse the response from PyPI: %w", err)
+	}
+	versions := []string{}
+	for version, files := range project.Releases {
+		for _, file := range files {
+			if !file.Yanked && supportsPython(file.RequiresPython, python) {
+				versions = append(versions, version)
+				break
+			}
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// supportsPython returns whether a Python version satisfies the requires_python of a file on PyPI. Files with a
+// requires_python that can't be parsed are assumed to support it.
+func supportsPython(requiresPython string, python string) bool {
+	if requiresPython == "" {
+		return true
+	}
+	req, err := config.ParseRequirement("python" + requiresPython)
+	if err != nil || req == nil {
+		return true
+	}
+	return req.Contains(python)
+}
@@ -0,0 +1,84 @@
+package dockerfile
+
+import (
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/config"
+)
+
+// runtimePackagesFile lists the Debian packages that own the shared libraries Python and the model's packages
+// link against, so the runtime stage of slim images can install them
+const runtimePackagesFile = "/tmp/runtime-packages.txt"
+
+// runtimeImage returns the image the runtime stage of slim images is built on. It has what's needed to run
+// Python, but not compilers and headers.
+func (g *Generator) runtimeImage() (string, error) {
+	if g.Config.Build.GPU {
+		if err := g.Config.ValidateAndComplete(g.Dir); err != nil {
+			return "", err
+		}
+		return g.Config.CUDARuntimeImageTag()
+	}
+	return "python:" + g.Config.Build.PythonVersion + "-slim", nil
+}
+
+// pythonDirs returns the directories Python and the packages installed in the builder stage are in
+func (g *Generator) pythonDirs() []string {
+	if !g.Config.Build.GPU {
+		return []string{"/usr/local"}
+	}
+	switch g.Config.Build.PythonInstall {
+	case config.PythonInstallStandalone, config.PythonInstallUV:
+		dirs := []string{"/root/.python"}
+		if g.usesUVImage() {
+			dirs = append(dirs, "/usr/local/bin/uv")
+		}
+		return dirs
+	default:
+		return []string{"/root/.pyenv"}
+	}
+}
+
+// findRuntimePackages writes the packages that own the shared libraries in the Python directories to
+// runtimePackagesFile. dpkg records some libraries under /lib and others under /usr/lib, so both are tried.
+func (g *Generator) findRuntimePackages() string {
+	return `RUN set -eu; \
+find ` + strings.Join(g.pythonDirs(), " ") + ` -type f \( -name '*.so*' -o -perm -u+x \) -print0 \
+| xargs -0 -r ldd 2>/dev/null \
+| awk '/=> \// { print $3 }' \
+| sort -u \
+| while read -r lib; do \
+    real="$(realpath "$lib")"; \
+    dpkg -S "$lib" 2>/dev/null \
+    || dpkg -S "$real" 2>/dev/null \
+    || dpkg -S "/usr$lib" 2>/dev/null \
+    || dpkg -S "${lib#/usr}" 2>/dev/null \
+    || true; \
+  done \
+| cut -d: -f1 \
+| sort -u > ` + runtimePackagesFile
+}
+
+// copyFromBuilder installs the packages found by findRuntimePackages in the runtime stage, and copies tini,
+// Python and the installed packages from the builder stage
+func (g *Generator) copyFromBuilder() string {
+	lines := []string{}
+	extraPackages := ""
+	if g.Config.Build.GPU {
+		lines = append(lines, g.pythonPathEnv())
+		// The CUDA runtime images don't have certificates, which Python needs to download weights over HTTPS
+		extraPackages = " ca-certificates"
+	}
+	lines = append(lines,
+		"COPY --from=builder "+runtimePackagesFile+" "+runtimePackagesFile,
+		`RUN --mount=type=cache,target=/var/cache/apt apt-get update -qq && \
+xargs -r -a `+runtimePackagesFile+` apt-get install -qqy --no-install-recommends`+extraPackages+` && \
+rm -rf /var/lib/apt/lists/* `+runtimePackagesFile,
+		"COPY --from=builder /sbin/tini /sbin/tini",
+		`ENTRYPOINT ["/sbin/tini", "--"]`,
+	)
+	for _, dir := range g.pythonDirs() {
+		lines = append(lines, "COPY --from=builder "+dir+" "+dir)
+	}
+	return strings.Join(lines, "\n")
+}
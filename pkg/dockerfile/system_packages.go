@@ -0,0 +1,126 @@
+package dockerfile
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/config"
+)
+
+// aptKeyringDir is where the keys of repositories in system_packages are saved, so each repository only trusts its key
+const aptKeyringDir = "/etc/apt/keyrings"
+
+// installSystemPackages installs build.system_packages. Build-only packages are left out of the runtime stage of
+// slim images.
+func (g *Generator) installSystemPackages(buildOnly bool) (string, error) {
+	packages := []config.SystemPackage{}
+	for _, p := range g.Config.Build.SystemPackages {
+		if buildOnly || !p.BuildOnly {
+			packages = append(packages, p)
+		}
+	}
+	if len(packages) == 0 {
+		return "", nil
+	}
+
+	distro := g.distro()
+	if distro != distroDebian {
+		return g.installOtherSystemPackages(distro, packages)
+	}
+
+	lines := []string{}
+	if repositories := g.addAptRepositories(packages); repositories != "" {
+		lines = append(lines, repositories)
+	}
+	mounts := ""
+	names := []string{}
+	for _, p := range packages {
+		if p.Deb == "" {
+			// Names and versions are checked to not have characters the shell would read
+			names = append(names, p.String())
+			continue
+		}
+		// .deb files are bind mounted from the project so they aren't copied into a layer of the image
+		target := "/tmp/debs/" + path.Base(p.Deb)
+		mounts += fmt.Sprintf("--mount=type=bind,source=%s,target=%s ", p.Deb, target)
+		names = append(names, target)
+	}
+	install := distro.installPackages(names)
+	lines = append(lines, strings.Replace(install, "RUN ", "RUN "+mounts, 1))
+	return strings.Join(lines, "\n"), nil
+}
+
+// installOtherSystemPackages installs system packages on Alpine and RHEL images, which only support names and versions
+func (g *Generator) installOtherSystemPackages(distro distro, packages []config.SystemPackage) (string, error) {
+	names := []string{}
+	for _, p := range packages {
+		if p.Repository != "" || p.Deb != "" {
+			return "", fmt.Errorf("system_packages in cog.yaml: repositories and .deb files can only be used with Debian and Ubuntu images, and %s is %s", g.Config.Build.BaseImage, distro)
+		}
+		name := p.Name
+		if p.Version != "" {
+			if distro == distroRHEL {
+				name += "-" + p.Version
+			} else {
+				name += "=" + p.Version
+			}
+		}
+		names = append(names, name)
+	}
+	return distro.installPackages(names), nil
+}
+
+// addAptRepositories returns a RUN instruction that adds the repositories of packages, and the keys they are
+// signed with. PPAs are added with add-apt-repository, which gets their keys from Launchpad.
+func (g *Generator) addAptRepositories(packages []config.SystemPackage) string {
+	repositories := []config.SystemPackage{}
+	seen := map[string]bool{}
+	hasPPA := false
+	for _, p := range packages {
+		if p.Repository == "" || seen[p.Repository] {
+			continue
+		}
+		seen[p.Repository] = true
+		repositories = append(repositories, p)
+		hasPPA = hasPPA || p.IsPPA()
+	}
+	if len(repositories) == 0 {
+		return ""
+	}
+
+	tools := "ca-certificates curl gnupg"
+	if hasPPA {
+		tools += " software-properties-common"
+	}
+	lines := []string{
+		`RUN --mount=type=cache,target=/var/cache/apt set -eux; \`,
+		`apt-get update -qq; \`,
+		`apt-get install -qqy --no-install-recommends ` + tools + `; \`,
+		`mkdir -p ` + aptKeyringDir + `; \`,
+	}
+	for i, p := range repositories {
+		if p.IsPPA() {
+			lines = append(lines, `add-apt-repository -y `+p.Repository+`; \`)
+			continue
+		}
+		source := p.Repository
+		if p.Key != "" {
+			keyring := fmt.Sprintf("%s/cog-%d.gpg", aptKeyringDir, i)
+			lines = append(lines, fmt.Sprintf(`curl -fsSL %s | gpg --dearmor -o %s; \`, shellQuote(p.Key), keyring))
+			source = signedBy(source, keyring)
+		}
+		lines = append(lines, fmt.Sprintf(`echo %s >> /etc/apt/sources.list.d/cog.list; \`, shellQuote(source)))
+	}
+	lines = append(lines, `rm -rf /var/lib/apt/lists/*`)
+	return strings.Join(lines, "\n")
+}
+
+// signedBy adds the signed-by option to a sources.list line, keeping the options it already has
+func signedBy(source string, keyring string) string {
+	rest := strings.TrimSpace(strings.TrimPrefix(source, "deb"))
+	if strings.HasPrefix(rest, "[") {
+		return "deb [signed-by=" + keyring + " " + strings.TrimPrefix(rest, "[")
+	}
+	return "deb [signed-by=" + keyring + "] " + rest
+}
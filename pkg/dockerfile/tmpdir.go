@@ -0,0 +1,130 @@
+package dockerfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+// tmpRoot is where generators make their temporary directories, relative to the project
+const tmpRoot = ".cog/tmp"
+
+// tmpBuildContext is the name of the build context the Dockerfile copies the generator's temporary files from.
+// Copying them from a named context, rather than from their path in the project, keeps the Dockerfile and its
+// cache keys the same in every build, although each build has its own directory.
+const tmpBuildContext = "cog-tmp"
+
+// unlockedTmpDirMaxAge is how old a temporary directory without a lock file has to be before it is removed,
+// because another cog may have just made it and not locked it yet
+const unlockedTmpDirMaxAge = time.Hour
+
+// makeTmpDir makes a temporary directory in the project that is only used by this generator, so builds of the
+// same project can run at the same time. It is locked until the generator is cleaned up, and the directories of
+// generators in processes that have exited without cleaning up are removed.
+func makeTmpDir(dir string) (string, *os.File, error) {
+	root := filepath.Join(dir, tmpRoot)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", nil, err
+	}
+	removeStaleTmpDirs(root)
+
+	tmpDir, err := os.MkdirTemp(root, "build-")
+	if err != nil {
+		return "", nil, err
+	}
+	lock, err := lockFile(tmpDir+".lock", true)
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return "", nil, err
+	}
+	return tmpDir, lock, nil
+}
+
+// lockFile opens path and takes an exclusive lock on it without waiting. The lock is released when the file is
+// closed or the process exits, even if it crashes. If create is set, the file is created and this process's ID
+// is written to it, to see which cog is using the directory.
+func lockFile(path string, create bool) (*os.File, error) {
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE | os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if create {
+		if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// removeStaleTmpDirs removes the temporary directories in root whose lock isn't held by a running cog, and lock
+// files left without a directory. Errors are only logged, because they don't stop this build.
+func removeStaleTmpDirs(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		console.Debugf("Failed to list temporary directories in %s: %s", root, err)
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "build-") {
+			continue
+		}
+		path := filepath.Join(root, entry.Name())
+		if !entry.IsDir() {
+			if strings.HasSuffix(path, ".lock") {
+				if _, err := os.Stat(strings.TrimSuffix(path, ".lock")); os.IsNotExist(err) {
+					removeStaleTmpDir("", path)
+				}
+			}
+			continue
+		}
+
+		if _, err := os.Stat(path + ".lock"); os.IsNotExist(err) {
+			info, err := entry.Info()
+			if err == nil && time.Since(info.ModTime()) > unlockedTmpDirMaxAge {
+				removeStaleTmpDir(path, "")
+			}
+			continue
+		}
+		removeStaleTmpDir(path, path+".lock")
+	}
+}
+
+// removeStaleTmpDir removes a temporary directory and its lock file, if the lock can be taken. Either can be
+// empty.
+func removeStaleTmpDir(tmpDir string, lockPath string) {
+	if lockPath != "" {
+		lock, err := lockFile(lockPath, false)
+		if err != nil {
+			// Another cog is using it
+			return
+		}
+		defer lock.Close()
+		// The lock file is removed after the directory, so it is still locked while the directory is removed
+		defer func() {
+			if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+				console.Debugf("Failed to remove %s: %s", lockPath, err)
+			}
+		}()
+	}
+	if tmpDir != "" {
+		console.Debugf("Removing temporary directory %s left by a build that didn't finish", tmpDir)
+		if err := os.RemoveAll(tmpDir); err != nil {
+			console.Debugf("Failed to remove %s: %s", tmpDir, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"github.com/sieve-data/cog/pkg/config"
 	"github.com/sieve-data/cog/pkg/docker"
 	"github.com/sieve-data/cog/pkg/dockerfile"
+	"github.com/sieve-data/cog/pkg/global"
 	"github.com/sieve-data/cog/pkg/util/console"
 )
 
@@ -15,9 +17,6 @@ import (
 //
 // This is separated out from docker.Build(), so that can be as close as possible to the behavior of 'docker build'.
 func Build(cfg *config.Config, dir, imageName string, progressOutput string, writer io.Writer, imagesToPull []string) (string, error) {
-	console.Info(fmt.Sprint("cudav version before validate and complete", cfg.Build.CUDA))
-	// cfg.ValidateAndCompleteCUDA()
-	console.Info(fmt.Sprint("cudav after before validate and complete", cfg.Build.CUDA))
 	console.Infof("Building Docker image from environment in cog.yaml as %s...", imageName)
 
 	generator, err := dockerfile.NewGenerator(cfg, dir)
@@ -35,19 +34,58 @@ func Build(cfg *config.Config, dir, imageName string, progressOutput string, wri
 		return "", fmt.Errorf("Failed to generate Dockerfile: %w", err)
 	}
 
-	if err := docker.Build(dir, dockerfileContents, imageName, progressOutput, writer, imagesToPull); err != nil {
+	labels, err := buildLabels(cfg, generator)
+	if err != nil {
+		return "", err
+	}
+	cache, err := buildCache(cfg, dir, imageName, imagesToPull)
+	if err != nil {
+		return "", err
+	}
+	dockerfile, err := generator.WriteDockerfile(dockerfileContents)
+	if err != nil {
+		return "", err
+	}
+	if err := docker.Build(dir, dockerfile, imageName, cfg.Platforms(), labels, progressOutput, writer, cache); err != nil {
 		return "", fmt.Errorf("Failed to build Docker image: %w", err)
 	}
 
-	console.Info("Adding labels to image...")
 	return dockerfileContents, nil
 }
 
-func Generate(cfg *config.Config, dir string) (string, string, error) {
-	console.Info(fmt.Sprint("cudav version before validate and complete", cfg.Build.CUDA))
-	// cfg.ValidateAndCompleteCUDA()
-	console.Info(fmt.Sprint("cudav after before validate and complete", cfg.Build.CUDA))
+// buildLabels returns labels that record how Cog made decisions about the image, and the digests of the
+// images and versions of the packages it was built from, so they can be audited later. The generator is
+// nil if the Dockerfile wasn't generated by this build.
+func buildLabels(cfg *config.Config, generator *dockerfile.Generator) (map[string]string, error) {
+	labels := map[string]string{}
+	if decision := cfg.CUDADecision(); decision != nil {
+		decisionJSON, err := json.Marshal(decision)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to convert CUDA decision to JSON: %w", err)
+		}
+		labels[global.LabelNamespace+"cuda_decision"] = string(decisionJSON)
+	}
+	if generator == nil {
+		return labels, nil
+	}
+	if pinnedImages := generator.PinnedImages(); len(pinnedImages) > 0 {
+		pinnedJSON, err := json.Marshal(pinnedImages)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to convert pinned images to JSON: %w", err)
+		}
+		labels[global.LabelNamespace+"pinned_images"] = string(pinnedJSON)
+	}
+	if extras := generator.ExtraRequirements(); len(extras) > 0 {
+		extrasJSON, err := json.Marshal(extras)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to convert runtime extras to JSON: %w", err)
+		}
+		labels[global.LabelNamespace+"runtime_extras"] = string(extrasJSON)
+	}
+	return labels, nil
+}
 
+func Generate(cfg *config.Config, dir string) (string, string, error) {
 	generator, err := dockerfile.NewGenerator(cfg, dir)
 	if err != nil {
 		return "", "", fmt.Errorf("Error creating Dockerfile generator: %w", err)
@@ -68,20 +106,6 @@ func Generate(cfg *config.Config, dir string) (string, string, error) {
 	return dockerfileContents, cogSHA256, nil
 }
 
-func BuildFromGenerate(cfg *config.Config, dir, imageName string, progressOutput string, writer io.Writer, dockerfileContents string) (string, error) {
-	console.Infof("Building Docker image from environment in cog.yaml as %s...", imageName)
-
-	imagesToPull := []string{}
-
-	if err := docker.Build(dir, dockerfileContents, imageName, progressOutput, writer, imagesToPull); err != nil {
-		return "", fmt.Errorf("Failed to build Docker image: %w", err)
-	}
-
-	console.Info("Adding labels to image...")
-
-	return dockerfileContents, nil
-}
-
 func BuildBase(cfg *config.Config, dir string, progressOutput string) (string, error) {
 	// TODO: better image management so we don't eat up disk space
 	// https://github.com/sieve-data/cog/issues/80
@@ -102,7 +126,16 @@ func BuildBase(cfg *config.Config, dir string, progressOutput string) (string, e
 	if err != nil {
 		return "", fmt.Errorf("Failed to generate Dockerfile: %w", err)
 	}
-	if err := docker.Build(dir, dockerfileContents, imageName, progressOutput, os.Stderr, imagesToPull); err != nil {
+	cache, err := buildCache(cfg, dir, imageName, imagesToPull)
+	if err != nil {
+		return "", err
+	}
+	dockerfile, err := generator.WriteDockerfile(dockerfileContents)
+	if err != nil {
+		return "", err
+	}
+	// The base image is run locally, so it is only built for one platform
+	if err := docker.Build(dir, dockerfile, imageName, cfg.Platforms()[:1], nil, progressOutput, os.Stderr, cache); err != nil {
 		return "", fmt.Errorf("Failed to build Docker image: %w", err)
 	}
 	return imageName, nil
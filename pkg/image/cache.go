@@ -0,0 +1,81 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/docker"
+)
+
+// buildCache returns where a build imports its cache from and exports it to. The --cache-from and --cache-to
+// flags override build.cache in cog.yaml, and if neither is set the cache is imported from the latest tag of
+// the image and imagesToPull. --cache-dir imports and exports a local cache as well.
+func buildCache(cfg *config.Config, dir string, imageName string, imagesToPull []string) (docker.BuildCache, error) {
+	cache := docker.BuildCache{NoCache: config.BuildNoCache}
+
+	branch := ""
+	if cfg.Build.Cache != nil && cfg.Build.Cache.Branch {
+		var err error
+		branch, err = gitBranch(dir)
+		if err != nil {
+			return cache, fmt.Errorf("cache.branch is set in cog.yaml, but Cog couldn't find the git branch: %w", err)
+		}
+	}
+
+	switch {
+	case len(config.BuildCacheFrom) > 0:
+		cache.From = config.BuildCacheFrom
+	case cfg.Build.Cache != nil:
+		for _, backend := range cfg.Build.Cache.From {
+			// Import the branch's cache first, and fall back to the default namespace's
+			if branch != "" {
+				cache.From = append(cache.From, backend.ImportArg(branch))
+			}
+			cache.From = append(cache.From, backend.ImportArg(""))
+		}
+	default:
+		imageLatest := strings.Split(imageName, ":")[0] + ":latest"
+		for _, image := range append([]string{imageLatest}, imagesToPull...) {
+			cache.From = append(cache.From, "type=registry,ref="+image)
+		}
+	}
+
+	switch {
+	case len(config.BuildCacheTo) > 0:
+		cache.To = config.BuildCacheTo
+	case cfg.Build.Cache != nil:
+		for _, backend := range cfg.Build.Cache.To {
+			cache.To = append(cache.To, backend.ExportArg(branch))
+		}
+	}
+
+	if config.BuildXCachePath != "" {
+		cache.From = append(cache.From, "type=local,src="+config.BuildXCachePath)
+		cache.To = append(cache.To, "type=local,dest="+config.BuildXCachePath+",mode=max")
+	}
+	return cache, nil
+}
+
+// gitBranch returns the branch checked out in the git repository dir is in. CI systems often check out a
+// commit instead of a branch, so on GitHub Actions the branch is read from the environment.
+func gitBranch(dir string) (string, error) {
+	for _, env := range []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME"} {
+		if branch := os.Getenv(env); branch != "" {
+			return branch, nil
+		}
+	}
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	branch := strings.TrimSpace(string(out))
+	if branch == "HEAD" {
+		return "", fmt.Errorf("HEAD isn't on a branch")
+	}
+	return branch, nil
+}
@@ -0,0 +1,101 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/dockerfile"
+)
+
+// DefaultEjectBuildDir is where cog dockerfile eject writes the files the Dockerfile copies
+const DefaultEjectBuildDir = "cog-build"
+
+// ejectDockerignore keeps Cog's own files and caches out of the build context of an ejected project. It is
+// added to the end of the project's .dockerignore if it has one.
+const ejectDockerignore = `# Written by cog dockerfile eject
+.git
+.cog
+**/__pycache__
+**/*.pyc
+`
+
+// Eject writes a Dockerfile, a .dockerignore and the files the Dockerfile copies, like requirements and the cog
+// wheel, to the project, so it can be built with docker build without Cog. The files are written to buildDir,
+// which is kept, unlike the temporary files of cog build. An existing Dockerfile and buildDir are only replaced
+// if force is set, and an existing .dockerignore is kept, with Cog's patterns added to it.
+// It returns the paths of the files and directories it wrote, relative to the project.
+func Eject(cfg *config.Config, dir string, buildDir string, force bool) ([]string, error) {
+	buildDir = filepath.Clean(buildDir)
+	if !filepath.IsLocal(buildDir) || buildDir == "." {
+		return nil, fmt.Errorf("The build directory must be a directory in the project, not %s", buildDir)
+	}
+	for _, name := range []string{"Dockerfile", buildDir} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil && !force {
+			return nil, fmt.Errorf("%s already exists. Use --force to replace it", name)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(dir, buildDir)); err != nil {
+		return nil, fmt.Errorf("Failed to remove %s: %w", buildDir, err)
+	}
+
+	generator, err := dockerfile.NewGeneratorWithBuildDir(cfg, dir, buildDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Dockerfile generator: %w", err)
+	}
+	dockerfileContents, err := generator.Generate()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate Dockerfile: %w", err)
+	}
+
+	// cog build adds these labels with --label, so they are added to the Dockerfile instead
+	labels, err := buildLabels(cfg, generator)
+	if err != nil {
+		return nil, err
+	}
+	dockerfileContents += labelInstructions(labels)
+
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfileContents+"\n"), 0o644); err != nil {
+		return nil, fmt.Errorf("Failed to write Dockerfile: %w", err)
+	}
+	dockerignore, err := os.ReadFile(filepath.Join(dir, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read .dockerignore: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".dockerignore"), mergeDockerignore(dockerignore), 0o644); err != nil {
+		return nil, fmt.Errorf("Failed to write .dockerignore: %w", err)
+	}
+	return []string{"Dockerfile", ".dockerignore", buildDir}, nil
+}
+
+// mergeDockerignore adds ejectDockerignore to the end of the project's .dockerignore, unless an earlier eject
+// already added it
+func mergeDockerignore(dockerignore []byte) []byte {
+	if strings.Contains(string(dockerignore), ejectDockerignore) {
+		return dockerignore
+	}
+	if len(dockerignore) > 0 && !strings.HasSuffix(string(dockerignore), "\n") {
+		dockerignore = append(dockerignore, '\n')
+	}
+	return append(dockerignore, ejectDockerignore...)
+}
+
+// labelInstructions returns LABEL instructions for labels, sorted so the Dockerfile is the same every time
+func labelInstructions(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Backslashes, quotes and dollar signs are escaped, so values like JSON aren't changed by the Dockerfile parser
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	out := ""
+	for _, k := range keys {
+		out += fmt.Sprintf("\nLABEL %s=\"%s\"", k, escaper.Replace(labels[k]))
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sieve-data/cog/pkg/config"
+)
+
+func TestEject(t *testing.T) {
+	cfg := &config.Config{
+		Build: &config.Build{
+			PythonVersion:  "3.11",
+			PythonPackages: []string{"torch==2.0.1"},
+			Sievedata:      &config.ExtraPackage{Disabled: true},
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := cfg.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := Eject(cfg, tmpDir, DefaultEjectBuildDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(written, " ") != "Dockerfile .dockerignore cog-build" {
+		t.Fatalf("Unexpected files written: %v", written)
+	}
+
+	dockerfile, err := os.ReadFile(filepath.Join(tmpDir, "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"COPY cog-build/requirements.txt /tmp/requirements.txt",
+		"COPY cog-build/cog-",
+		`LABEL run.cog.runtime_extras="{\"cog\":`,
+	} {
+		if !strings.Contains(string(dockerfile), expected) {
+			t.Fatalf("Expected Dockerfile to contain:\n%s\n\nGot:\n%s", expected, dockerfile)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "cog-build/requirements.txt")); err != nil {
+		t.Fatalf("Expected requirements.txt to be kept: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".dockerignore")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Eject(cfg, tmpDir, DefaultEjectBuildDir, false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected an error replacing the Dockerfile, got %v", err)
+	}
+	if _, err := Eject(cfg, tmpDir, DefaultEjectBuildDir, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Eject(cfg, tmpDir, ".", true); err == nil {
+		t.Fatal("Expected an error ejecting to the project directory")
+	}
+}
+
+func TestEjectMergesDockerignore(t *testing.T) {
+	cfg := &config.Config{
+		Build: &config.Build{
+			PythonVersion: "3.11",
+			Sievedata:     &config.ExtraPackage{Disabled: true},
+		},
+	}
+	tmpDir := t.TempDir()
+	if err := cfg.ValidateAndComplete(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, ".dockerignore"), []byte("weights"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Eject(cfg, tmpDir, DefaultEjectBuildDir, false); err != nil {
+		t.Fatal(err)
+	}
+	// Ejecting again doesn't add Cog's patterns twice
+	if _, err := Eject(cfg, tmpDir, DefaultEjectBuildDir, true); err != nil {
+		t.Fatal(err)
+	}
+	dockerignore, err := os.ReadFile(filepath.Join(tmpDir, ".dockerignore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dockerignore) != "weights\n"+ejectDockerignore {
+		t.Fatalf("Expected the project's .dockerignore to be kept, with Cog's patterns added, got:\n%s", dockerignore)
+	}
+}
+
+func TestLabelInstructions(t *testing.T) {
+	actual := labelInstructions(map[string]string{
+		"run.cog.b": `{"a":"$HOME\n"}`,
+		"run.cog.a": "1",
+	})
+	expected := "\nLABEL run.cog.a=\"1\"\nLABEL run.cog.b=\"{\\\"a\\\":\\\"\\$HOME\\\\n\\\"}\""
+	if actual != expected {
+		t.Fatalf("Expected %q, got %q", expected, actual)
+	}
+}
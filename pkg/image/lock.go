@@ -0,0 +1,69 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sieve-data/cog/pkg/config"
+	"github.com/sieve-data/cog/pkg/docker"
+	"github.com/sieve-data/cog/pkg/dockerfile"
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+// uvPythonPlatforms are the values of uv's --python-platform for each architecture
+var uvPythonPlatforms = map[string]string{
+	"amd64": "x86_64-unknown-linux-gnu",
+	"arm64": "aarch64-unknown-linux-gnu",
+}
+
+// Lock resolves the Python requirements for each platform, with the CUDA builds of packages Cog picks,
+// and writes them with hashes to lockfiles in dir. It returns the names of the lockfiles.
+func Lock(cfg *config.Config, dir string) ([]string, error) {
+	filenames := []string{}
+	for _, platform := range cfg.Platforms() {
+		goos, goarch, err := config.ParsePlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+		requirements, err := cfg.PythonRequirementsForArch(goos, goarch, []string{})
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(requirements) == "" {
+			return nil, fmt.Errorf("There are no Python requirements in cog.yaml to lock")
+		}
+		header, err := cfg.RequirementsLockHeader(goos, goarch)
+		if err != nil {
+			return nil, err
+		}
+
+		console.Infof("Resolving Python requirements for %s...", platform)
+		var stdout bytes.Buffer
+		err = docker.RunWithIO(docker.RunOptions{
+			Image: dockerfile.UVImage,
+			Args: []string{
+				"pip", "compile", "-",
+				"--python-version", cfg.Build.PythonVersion,
+				"--python-platform", uvPythonPlatforms[goarch],
+				"--generate-hashes",
+				"--emit-index-url",
+				"--emit-find-links",
+				"--no-header",
+				"--quiet",
+			},
+		}, strings.NewReader(requirements), &stdout, os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve Python requirements for %s: %w", platform, err)
+		}
+
+		filename := cfg.RequirementsLockFilenameForArch(goarch)
+		if err := os.WriteFile(filepath.Join(dir, filename), append([]byte(header), stdout.Bytes()...), 0o644); err != nil {
+			return nil, fmt.Errorf("Failed to write %s: %w", filename, err)
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/anaskhan96/soup"
+	"github.com/sieve-data/cog/pkg/util/console"
+)
+
+// source is a web page that the compatibility matrices are generated from. The fetch step saves each
+// source to a file, so the parse step can run offline, and tests can run against recorded pages.
+type source struct {
+	Filename string
+	URL      string
+}
+
+var (
+	tfSource                    = source{"tensorflow_install_source.html", "https://www.tensorflow.org/install/source"}
+	torchPreviousVersionsSource = source{"pytorch_previous_versions.html", "https://pytorch.org/get-started/previous-versions/"}
+	torchWheelsSources          = map[string]source{
+		"torch":       {"pytorch_whl_torch.html", "https://download.pytorch.org/whl/torch/"},
+		"torchvision": {"pytorch_whl_torchvision.html", "https://download.pytorch.org/whl/torchvision/"},
+		"torchaudio":  {"pytorch_whl_torchaudio.html", "https://download.pytorch.org/whl/torchaudio/"},
+	}
+	cudaImagesSource = source{"nvidia_cuda_tags.json", "https://hub.docker.com/v2/repositories/nvidia/cuda/tags/?page_size=1000&name=devel-ubuntu&ordering=last_updated"}
+)
+
+// fetchSources downloads all the sources into dir
+func fetchSources(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	pages := []source{tfSource, torchPreviousVersionsSource}
+	for _, name := range []string{"torch", "torchvision", "torchaudio"} {
+		pages = append(pages, torchWheelsSources[name])
+	}
+	for _, page := range pages {
+		console.Infof("Fetching %s...", page.URL)
+		resp, err := soup.Get(page.URL)
+		if err != nil {
+			return fmt.Errorf("Failed to download %s: %w", page.URL, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, page.Filename), []byte(resp), 0o644); err != nil {
+			return err
+		}
+	}
+
+	console.Infof("Fetching %s...", cudaImagesSource.URL)
+	tags, err := fetchCUDABaseImageTags(cudaImagesSource.URL)
+	if err != nil {
+		return err
+	}
+	// Save all the pages as one, so parsing doesn't need to know about pagination
+	data, err := json.MarshalIndent(cudaImageTagsPage{Results: tags}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, cudaImagesSource.Filename), data, 0o644)
+}
+
+// cudaImageTagsPage is a page of results from the Docker Hub tags API
+type cudaImageTagsPage struct {
+	Next    *string        `json:"next,omitempty"`
+	Results []cudaImageTag `json:"results"`
+}
+
+type cudaImageTag struct {
+	Name string `json:"name"`
+}
+
+func fetchCUDABaseImageTags(url string) ([]cudaImageTag, error) {
+	resp, err := soup.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download %s: %w", url, err)
+	}
+
+	var page cudaImageTagsPage
+	if err := json.Unmarshal([]byte(resp), &page); err != nil {
+		return nil, fmt.Errorf("Failed parse CUDA images json: %w", err)
+	}
+	results := page.Results
+
+	// recursive case for pagination
+	if page.Next != nil {
+		nextResults, err := fetchCUDABaseImageTags(*page.Next)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, nextResults...)
+	}
+	return results, nil
+}
+
+// readSource reads a fetched source from dir
+func readSource(dir string, s source) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, s.Filename))
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %s, which is fetched from %s: %w", s.Filename, s.URL, err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sieve-data/cog/pkg/config"
+)
+
+// The pages in testdata are trimmed copies of the pages saved by `fetch -sources-dir testdata`
+
+func readTestSource(t *testing.T, s source) string {
+	html, err := readSource("testdata", s)
+	require.NoError(t, err)
+	return html
+}
+
+func TestParseTFCompatibilityMatrix(t *testing.T) {
+	compats, err := parseTFCompatibilityMatrix(readTestSource(t, tfSource))
+	require.NoError(t, err)
+	require.Equal(t, []config.TFCompatibility{{
+		TF:           "2.16.1",
+		TFCPUPackage: "tensorflow==2.16.1",
+		TFGPUPackage: "tensorflow==2.16.1",
+		CUDA:         "12.3",
+		CuDNN:        "8.9",
+		Pythons:      []string{"3.9", "3.10", "3.11", "3.12"},
+	}, {
+		TF:           "2.15.0",
+		TFCPUPackage: "tensorflow==2.15.0",
+		TFGPUPackage: "tensorflow==2.15.0",
+		CUDA:         "12.2",
+		CuDNN:        "8.9",
+		Pythons:      []string{"3.9", "3.10", "3.11"},
+	}, {
+		TF:           "2.11.0",
+		TFCPUPackage: "tensorflow==2.11.0",
+		TFGPUPackage: "tensorflow==2.11.0",
+		CUDA:         "11.2",
+		CuDNN:        "8.1",
+		Pythons:      []string{"3.7", "3.8", "3.9", "3.10"},
+	}, {
+		TF:           "2.10.0",
+		TFCPUPackage: "tensorflow==2.10.0",
+		TFGPUPackage: "tensorflow_gpu==2.10.0",
+		CUDA:         "11.2",
+		CuDNN:        "8.1",
+		Pythons:      []string{"3.7", "3.8", "3.9", "3.10"},
+	}}, compats)
+}
+
+func TestParseTorchCompatibilityMatrix(t *testing.T) {
+	compats, err := parseTorchCompatibilityMatrix(
+		readTestSource(t, torchWheelsSources["torch"]),
+		readTestSource(t, torchWheelsSources["torchvision"]),
+		readTestSource(t, torchWheelsSources["torchaudio"]),
+		readTestSource(t, torchPreviousVersionsSource),
+	)
+	require.NoError(t, err)
+
+	cuda := func(s string) *string { return &s }
+	require.Equal(t, []config.TorchCompatibility{
+		// The latest release comes from the wheel indexes, and ROCm and Windows wheels are ignored
+		{Torch: "2.3.1+cpu", Torchvision: "0.18.1", Torchaudio: "2.3.1", ExtraIndexURL: "https://download.pytorch.org/whl/cpu", Pythons: []string{"3.10", "3.11", "3.12"}},
+		{Torch: "2.3.1+cu118", Torchvision: "0.18.1", Torchaudio: "2.3.1", ExtraIndexURL: "https://download.pytorch.org/whl/cu118", CUDA: cuda("11.8"), Pythons: []string{"3.10", "3.11", "3.12"}},
+		{Torch: "2.3.1+cu121", Torchvision: "0.18.1", Torchaudio: "2.3.1", ExtraIndexURL: "https://download.pytorch.org/whl/cu121", CUDA: cuda("12.1"), Pythons: []string{"3.10", "3.11", "3.12"}},
+		// Previous releases come from the previous versions page, and conda and ROCm commands are ignored
+		{Torch: "2.1.0", Torchvision: "0.16.0", Torchaudio: "2.1.0", ExtraIndexURL: "https://download.pytorch.org/whl/cu118", CUDA: cuda("11.8"), Pythons: []string{"3.6", "3.7", "3.8", "3.9", "3.10", "3.11"}},
+		{Torch: "2.1.0", Torchvision: "0.16.0", Torchaudio: "2.1.0", ExtraIndexURL: "https://download.pytorch.org/whl/cu121", CUDA: cuda("12.1"), Pythons: []string{"3.6", "3.7", "3.8", "3.9", "3.10", "3.11"}},
+		{Torch: "2.1.0", Torchvision: "0.16.0", Torchaudio: "2.1.0", ExtraIndexURL: "https://download.pytorch.org/whl/cpu", Pythons: []string{"3.6", "3.7", "3.8", "3.9", "3.10", "3.11"}},
+		{Torch: "1.7.1+cu110", Torchvision: "0.8.2+cu110", Torchaudio: "0.7.2", FindLinks: "https://download.pytorch.org/whl/torch_stable.html", CUDA: cuda("11.0"), Pythons: []string{"3.6", "3.7", "3.8", "3.9", "3.10", "3.11"}},
+		{Torch: "1.7.1+cpu", Torchvision: "0.8.2+cpu", Torchaudio: "0.7.2", FindLinks: "https://download.pytorch.org/whl/torch_stable.html", Pythons: []string{"3.6", "3.7", "3.8", "3.9", "3.10", "3.11"}},
+		// torchvision 0.8.0 is fixed to 0.8.1
+		{Torch: "1.7.0", Torchvision: "0.8.1", Torchaudio: "0.7.0", CUDA: cuda("10.2"), Pythons: []string{"3.6", "3.7", "3.8", "3.9", "3.10", "3.11"}},
+	}, compats)
+}
+
+func TestParseCUDABaseImages(t *testing.T) {
+	images, err := parseCUDABaseImages(readTestSource(t, cudaImagesSource))
+	require.NoError(t, err)
+	require.Equal(t, []config.CUDABaseImage{
+		{Tag: "12.3.2-cudnn9-devel-ubuntu22.04", CUDA: "12.3.2", CuDNN: "9", IsDevel: true, Ubuntu: "22.04"},
+		{Tag: "12.3.2-cudnn9-devel-ubuntu20.04", CUDA: "12.3.2", CuDNN: "9", IsDevel: true, Ubuntu: "20.04"},
+		{Tag: "12.1.1-cudnn8-devel-ubuntu22.04", CUDA: "12.1.1", CuDNN: "8", IsDevel: true, Ubuntu: "22.04"},
+		{Tag: "11.8.0-cudnn8-devel-ubuntu22.04", CUDA: "11.8.0", CuDNN: "8", IsDevel: true, Ubuntu: "22.04"},
+	}, images)
+}
+
+func TestParseTFCompatibilityMatrixChangedPage(t *testing.T) {
+	_, err := parseTFCompatibilityMatrix("<html><body><h4 id=\"cpu\">CPU</h4></body></html>")
+	require.ErrorContains(t, err, "GPU heading")
+}